@@ -1,33 +1,80 @@
 package main
 
 import (
+	"bytes"
 	"github.com/go-gl/gl/v4.1-core/gl"
 	"github.com/tonybillings/gfx"
 	"image/color"
+	"math"
 	"sync"
 )
 
+const (
+	maxUndoStrokes = 50 // strokes that can be undone
+
+	// defaultDrainRate has a tank last about as long as when each stamp of
+	// the brush head drained the ink for its whole area, which took 2.5 tanks
+	// per window's worth of pixels.  A stroke moving by half the head's
+	// radius each frame stamped pi*radius^2 pixels a frame, but changes only
+	// about radius^2 of them.
+	defaultDrainRate = 2.5 * math.Pi
+
+	refillRate = .00025 // of a tank, per update
+)
+
 /******************************************************************************
  InkBrush
 ******************************************************************************/
 
+// InkBrush is a brush that uses up ink as it paints, from the tanks of its
+// InkModel, which must be refilled once they run dry.  Ink is only used for
+// the area of the canvas actually changed by the paint.  The brush head is
+// stamped along the whole path between mouse samples, rather than only at
+// each sample, so that fast strokes and slow frames leave no gaps.
+//
+// The brush paints into its own copy of the canvas surface, which it keeps as
+// the authoritative one, reading the texture back only before the first
+// stroke and after the surface is resized, and uploads just the region
+// painted, once per frame, so that the cost of painting follows the size of
+// the brush rather than that of the canvas.  The canvas must therefore be
+// cleared with ClearCanvas, rather than by the canvas itself.
+//
+// Strokes can be undone and redone, with the ink they took either refunded or
+// kept as set with SetRefundInkOnUndo.  Undo and redo are applied to the
+// canvas surface on the next update, before it can next be captured, and are
+// forgotten once the canvas is cleared.
 type InkBrush struct {
 	gfx.BasicBrush
 
-	canvasBuffer []uint8
+	canvasBuffer []uint8   // the brush's copy of the canvas surface
+	bufferWidth  int       // the width of the canvas surface, in pixels
+	dirty        dirtyRect // the region of the buffer not yet uploaded
 
-	redInk   float64
-	greenInk float64
-	blueInk  float64
+	// upload sends a region of the buffer to the canvas surface.
+	upload func(buffer []uint8, width int, rect dirtyRect)
+
+	model  InkModel
+	levels []float64 // one per tank of the model, from 0 to 1
 
 	drainRate    float64
 	drainRateMod float64
 
 	refilling bool
+	inkUsed   float64
+
+	stroke    []strokePoint // the most recent samples of the stroke being painted
+	smoothing bool
+
+	strokeTiles strokeTiles // the pixels painted over by the stroke, as they were
+	strokeRect  dirtyRect   // the region changed by the stroke
+	strokeInk   []float64   // the ink taken from each tank by the stroke
 
-	onRedInkChanged   func(float64)
-	onGreenInkChanged func(float64)
-	onBlueInkChanged  func(float64)
+	history         strokeHistory
+	historyRequests []historyRequest
+	refundInk       bool
+
+	onInkChanged      func([]float64)
+	onInkModelChanged func(InkModel)
 
 	stateMutex sync.Mutex
 }
@@ -46,16 +93,41 @@ func (b *InkBrush) Init() (ok bool) {
 	return true
 }
 
+// Update paints, rather than having the BasicBrush do so, as it would also
+// read the whole canvas surface back at the start of every stroke, for an
+// undo that the InkBrush does its own way.
 func (b *InkBrush) Update(deltaTime int64) (ok bool) {
-	if b.refilling {
+	if !b.ObjectBase.Update(deltaTime) {
+		return false
+	}
+
+	// A stroke ends once the mouse button is released.
+	var mouse *gfx.MouseState
+	if canvas := b.Canvas(); canvas != nil {
+		mouse = canvas.Mouse()
+	}
+	drawing := mouse != nil && mouse.PrimaryDown
+
+	b.stateMutex.Lock()
+	refilling := b.refilling
+	if !drawing || refilling {
+		b.endStroke()
+	}
+	b.stateMutex.Unlock()
+
+	b.applyHistoryRequests()
+
+	if refilling {
 		b.stateMutex.Lock()
 		b.refillInk()
 		b.stateMutex.Unlock()
 		b.dispatchEvents()
-		return true
+	} else if drawing {
+		b.updateCanvas(mouse)
 	}
 
-	return b.BasicBrush.Update(deltaTime)
+	b.flushCanvas()
+	return true
 }
 
 /******************************************************************************
@@ -84,48 +156,26 @@ func (b *InkBrush) initDrainRateMod() {
 	b.drainRateMod = 1 / (winWidth * winHeight)
 }
 
-func (b *InkBrush) getBrushProperties() (textureColor color.RGBA, redDrain, greenDrain, blueDrain float64) {
+// getBrushProperties must be called with the state mutex held.
+func (b *InkBrush) getBrushProperties() (textureColor color.RGBA, drains []float64) {
 	textureColor = b.Color()
-	redDrain = (float64(textureColor.R) / 255.0) * b.drainRate * b.drainRateMod
-	greenDrain = (float64(textureColor.G) / 255.0) * b.drainRate * b.drainRateMod
-	blueDrain = (float64(textureColor.B) / 255.0) * b.drainRate * b.drainRateMod
-
-	if b.redInk <= 0 {
-		textureColor.R = 0
+	drains = b.model.Drain(textureColor)
+	for i := range drains {
+		drains[i] *= b.drainRate * b.drainRateMod
 	}
-
-	if b.greenInk <= 0 {
-		textureColor.G = 0
-	}
-
-	if b.blueInk <= 0 {
-		textureColor.B = 0
-	}
-
 	return
 }
 
 func (b *InkBrush) refillInk() {
-	if b.redInk < 1.0 {
-		b.redInk += b.drainRate * .0001
-		if b.redInk > 1.0 {
-			b.redInk = 1.0
-		}
-	}
-	if b.greenInk < 1.0 {
-		b.greenInk += b.drainRate * .0001
-		if b.greenInk > 1.0 {
-			b.greenInk = 1.0
-		}
-	}
-	if b.blueInk < 1.0 {
-		b.blueInk += b.drainRate * .0001
-		if b.blueInk > 1.0 {
-			b.blueInk = 1.0
+	full := true
+	for i, level := range b.levels {
+		if level < 1.0 {
+			b.levels[i] = math.Min(level+refillRate, 1.0)
+			full = full && b.levels[i] == 1.0
 		}
 	}
 
-	if b.redInk == 1.0 && b.greenInk == 1.0 && b.blueInk == 1.0 {
+	if full {
 		b.refilling = false
 	}
 }
@@ -135,37 +185,232 @@ func (b *InkBrush) updateCanvas(mouse *gfx.MouseState) {
 	width := surface.Width()
 	height := surface.Height()
 
-	textureColor, redDrain, greenDrain, blueDrain := b.getBrushProperties()
-	tx := int((mouse.X + 1) / 2 * float32(width))
-	ty := int((mouse.Y + 1) / 2 * float32(height))
+	sample := strokePoint{
+		x: float64((mouse.X + 1) / 2 * float32(width)),
+		y: float64((mouse.Y + 1) / 2 * float32(height)),
+	}
 	radius := int(b.Size() * (float32(width) * 0.5))
 
 	b.stateMutex.Lock()
 
-	if b.canvasBuffer == nil {
-		b.canvasBuffer = make([]uint8, width*height*4)
+	if len(b.stroke) == 0 {
+		b.startStroke(surface)
+	}
+	painted := b.paintStroke(sample, width, height, radius)
+
+	b.stateMutex.Unlock()
+
+	if painted {
+		b.dispatchEvents()
+	}
+}
+
+// paintStroke adds the sample to the stroke being painted and paints the
+// stroke up to it into the buffer, returning false if there was nothing to
+// paint.  It must be called with the state mutex held.
+func (b *InkBrush) paintStroke(sample strokePoint, width, height, radius int) bool {
+	b.stroke = append(b.stroke, sample)
+	if len(b.stroke) > 3 {
+		b.stroke = b.stroke[len(b.stroke)-3:]
+	}
+
+	// Stamps overlap, which costs no extra ink as only the area newly changed
+	// drains the tanks, so the ink used follows the length of the stroke.
+	stamps := interpolateStroke(b.stroke, math.Max(float64(radius)/2, 1), b.smoothing)
+	if len(stamps) == 0 {
+		return false
+	}
+
+	textureColor, drains := b.getBrushProperties()
+
+	brushHead := b.BrushHead()
+	for _, stamp := range stamps {
+		tx, ty := int(math.Round(stamp.x)), int(math.Round(stamp.y))
+		stampRect := newDirtyRect(tx-radius, ty-radius, tx+radius+1, ty+radius+1).clip(width, height)
+		b.strokeTiles.save(b.canvasBuffer, width, height, stampRect)
+
+		switch brushHead {
+		case gfx.RoundBrushHead:
+			b.updateCanvasRoundHead(width, height, textureColor, drains, radius, tx, ty)
+		case gfx.SquareBrushHead:
+			b.updateCanvasSquareHead(width, height, textureColor, drains, radius, tx, ty)
+		}
+		b.dirty = b.dirty.union(stampRect)
+		b.strokeRect = b.strokeRect.union(stampRect)
+	}
+
+	return true
+}
+
+// syncCanvasBuffer reads the canvas surface back into the buffer, sized to
+// fit, unless the buffer already fits, in which case it is already the same
+// as the surface, the brush being the only thing to paint the surface but for
+// clears, which ClearCanvas applies to both.  A buffer that does not fit has
+// yet to be read back or was read back before the surface was resized, which
+// leaves nothing to undo.  It must be called with the state mutex held.
+func (b *InkBrush) syncCanvasBuffer(surface gfx.Texture) {
+	width := surface.Width()
+	height := surface.Height()
+
+	if len(b.canvasBuffer) == width*height*4 {
+		return
 	}
 
+	b.canvasBuffer = make([]uint8, width*height*4)
+	b.bufferWidth = width
+	b.dirty = dirtyRect{}
+	b.history.clear()
+
 	gl.BindTexture(gl.TEXTURE_2D, surface.GlName())
 	gl.GetTexImage(gl.TEXTURE_2D, 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(&b.canvasBuffer[0]))
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+}
+
+// startStroke must be called with the state mutex held.
+func (b *InkBrush) startStroke(surface gfx.Texture) {
+	b.syncCanvasBuffer(surface)
+	b.beginStroke()
+}
 
-	switch b.BrushHead() {
-	case gfx.RoundBrushHead:
-		b.updateCanvasRoundHead(width, height, textureColor, redDrain, greenDrain, blueDrain, radius, tx, ty)
-	case gfx.SquareBrushHead:
-		b.updateCanvasSquareHead(width, height, textureColor, redDrain, greenDrain, blueDrain, radius, tx, ty)
+// beginStroke prepares for a stroke to be painted into the buffer, as synced
+// with the canvas surface.  It must be called with the state mutex held.
+func (b *InkBrush) beginStroke() {
+	if b.strokeTiles == nil {
+		b.strokeTiles = make(strokeTiles)
 	}
+	clear(b.strokeTiles)
+	b.strokeRect = dirtyRect{}
+	b.strokeInk = make([]float64, len(b.levels))
+}
 
-	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, int32(width), int32(height), 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(b.canvasBuffer))
-	gl.BindTexture(gl.TEXTURE_2D, 0)
+// endStroke records the stroke being painted, if any and if it changed the
+// canvas, so that it can be undone.  It must be called with the state mutex
+// held.
+func (b *InkBrush) endStroke() {
+	if len(b.stroke) > 0 && !b.strokeRect.empty() {
+		record := newStrokeRecord(b.canvasBuffer, b.bufferWidth, b.strokeRect, b.strokeTiles, b.strokeInk)
+		if !bytes.Equal(record.before, record.after) {
+			b.history.push(record)
+		}
+	}
+
+	b.stroke = nil
+	b.strokeRect = dirtyRect{}
+	clear(b.strokeTiles)
+}
+
+// applyHistoryRequests undoes and redoes strokes as requested since the last
+// update, unless a stroke is being painted, in which case they wait for it to
+// end.
+func (b *InkBrush) applyHistoryRequests() {
+	b.stateMutex.Lock()
+
+	if len(b.historyRequests) == 0 || len(b.stroke) > 0 {
+		b.stateMutex.Unlock()
+		return
+	}
+
+	requests := b.historyRequests
+	b.historyRequests = nil
+
+	changed := false
+	for _, request := range requests {
+		changed = b.applyHistoryRequest(request) || changed
+	}
 
 	b.stateMutex.Unlock()
 
-	b.dispatchEvents()
+	if changed {
+		b.dispatchEvents()
+	}
+}
+
+// applyHistoryRequest must be called with the state mutex held.
+func (b *InkBrush) applyHistoryRequest(request historyRequest) bool {
+	record := b.history.lastUndo()
+	if request == redoRequest {
+		record = b.history.lastRedo()
+	}
+
+	if record == nil {
+		return false
+	}
+
+	from, to := record.after, record.before
+	if request == redoRequest {
+		from, to = record.before, record.after
+	}
+
+	if !regionEquals(b.canvasBuffer, b.bufferWidth, record.rect, from) {
+		b.history.clear()
+		return false
+	}
+
+	writeRegion(b.canvasBuffer, b.bufferWidth, record.rect, to)
+	b.dirty = b.dirty.union(record.rect)
+
+	if request == undoRequest {
+		b.history.undoLast()
+	} else {
+		b.history.redoLast()
+	}
+
+	if b.refundInk && len(record.ink) == len(b.levels) {
+		for i, ink := range record.ink {
+			if request == undoRequest {
+				refunded := math.Min(ink, 1-b.levels[i])
+				b.levels[i] += refunded
+				b.inkUsed = math.Max(b.inkUsed-refunded, 0)
+			} else {
+				taken := math.Min(ink, b.levels[i])
+				b.levels[i] -= taken
+				b.inkUsed += taken
+			}
+		}
+	}
+
+	return true
+}
+
+// flushCanvas uploads the region of the buffer painted since the last flush
+// to the canvas surface.
+func (b *InkBrush) flushCanvas() {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+
+	if b.dirty.empty() {
+		return
+	}
+
+	dirty := b.dirty
+	b.dirty = dirtyRect{}
+
+	b.upload(b.canvasBuffer, b.bufferWidth, dirty)
+}
+
+// uploadRegion sends the given region of the buffer to the canvas surface,
+// unless the surface was resized since the buffer was read back.
+func (b *InkBrush) uploadRegion(buffer []uint8, width int, rect dirtyRect) {
+	canvas := b.Canvas()
+	if canvas == nil {
+		return
+	}
+
+	surface := canvas.Surface()
+	if surface.Width() != width || len(buffer) != width*surface.Height()*4 {
+		return // the surface was resized mid-stroke
+	}
+
+	gl.BindTexture(gl.TEXTURE_2D, surface.GlName())
+	gl.PixelStorei(gl.UNPACK_ROW_LENGTH, int32(width))
+	gl.TexSubImage2D(gl.TEXTURE_2D, 0, int32(rect.minX), int32(rect.minY), int32(rect.width()), int32(rect.height()),
+		gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(&buffer[(rect.minY*width+rect.minX)*4]))
+	gl.PixelStorei(gl.UNPACK_ROW_LENGTH, 0)
+	gl.BindTexture(gl.TEXTURE_2D, 0)
 }
 
 func (b *InkBrush) updateCanvasRoundHead(surfaceWidth, surfaceHeight int,
-	textureColor color.RGBA, redDrain, greenDrain, blueDrain float64, radius, tx, ty int) {
+	textureColor color.RGBA, drains []float64, radius, tx, ty int) {
 	for i := -radius; i <= radius; i++ {
 		for j := -radius; j <= radius; j++ {
 			if i*i+j*j <= radius*radius {
@@ -173,7 +418,7 @@ func (b *InkBrush) updateCanvasRoundHead(surfaceWidth, surfaceHeight int,
 				py := ty + j
 				if px >= 0 && px < surfaceWidth && py >= 0 && py < surfaceHeight {
 					index := (py*surfaceWidth + px) * 4
-					b.paintCanvas(index, &textureColor, redDrain, greenDrain, blueDrain)
+					b.paintCanvas(index, textureColor, drains)
 				}
 			}
 		}
@@ -181,63 +426,74 @@ func (b *InkBrush) updateCanvasRoundHead(surfaceWidth, surfaceHeight int,
 }
 
 func (b *InkBrush) updateCanvasSquareHead(surfaceWidth, surfaceHeight int,
-	textureColor color.RGBA, redDrain, greenDrain, blueDrain float64, radius, tx, ty int) {
+	textureColor color.RGBA, drains []float64, radius, tx, ty int) {
 	for i := -radius; i <= radius; i++ {
 		for j := -radius; j <= radius; j++ {
 			px := tx + i
 			py := ty + j
 			if px >= 0 && px < surfaceWidth && py >= 0 && py < surfaceHeight {
 				index := (py*surfaceWidth + px) * 4
-				b.paintCanvas(index, &textureColor, redDrain, greenDrain, blueDrain)
+				b.paintCanvas(index, textureColor, drains)
 			}
 		}
 	}
 }
 
-func (b *InkBrush) paintCanvas(index int, textureColor *color.RGBA,
-	redDrain, greenDrain, blueDrain float64) {
-	if b.redInk > 0 {
-		b.redInk -= redDrain
-		if b.redInk <= 0 {
-			b.redInk = 0
-			textureColor.R = 0
-		}
+// paintCanvas paints the pixel at the given index of the canvas buffer,
+// draining ink in proportion to how much the pixel changes, such that going
+// over pixels already painted that color (or holding the brush still) takes
+// no ink, however many times the canvas is updated.
+func (b *InkBrush) paintCanvas(index int, textureColor color.RGBA, drains []float64) {
+	painted, ok := b.model.Paint(textureColor, b.levels)
+	if !ok {
+		return
 	}
-	b.canvasBuffer[index] = textureColor.R
 
-	if b.greenInk > 0 {
-		b.greenInk -= greenDrain
-		if b.greenInk <= 0 {
-			b.greenInk = 0
-			textureColor.G = 0
-		}
+	existing := color.RGBA{
+		R: b.canvasBuffer[index],
+		G: b.canvasBuffer[index+1],
+		B: b.canvasBuffer[index+2],
+		A: b.canvasBuffer[index+3],
+	}
+
+	delta := colorDelta(existing, painted)
+	if delta == 0 {
+		return
 	}
-	b.canvasBuffer[index+1] = textureColor.G
 
-	if b.blueInk > 0 {
-		b.blueInk -= blueDrain
-		if b.blueInk <= 0 {
-			b.blueInk = 0
-			textureColor.B = 0
+	for i, drain := range drains {
+		if b.levels[i] > 0 {
+			used := math.Min(drain*delta, b.levels[i])
+			b.levels[i] -= used
+			b.inkUsed += used
+			b.strokeInk[i] += used
 		}
 	}
-	b.canvasBuffer[index+2] = textureColor.B
 
-	b.canvasBuffer[index+3] = textureColor.A
+	b.canvasBuffer[index] = painted.R
+	b.canvasBuffer[index+1] = painted.G
+	b.canvasBuffer[index+2] = painted.B
+	b.canvasBuffer[index+3] = painted.A
 }
 
 func (b *InkBrush) dispatchEvents() {
-	if b.onRedInkChanged != nil {
-		b.onRedInkChanged(b.redInk)
-	}
+	b.stateMutex.Lock()
+	handler := b.onInkChanged
+	levels := append([]float64(nil), b.levels...)
+	b.stateMutex.Unlock()
 
-	if b.onGreenInkChanged != nil {
-		b.onGreenInkChanged(b.greenInk)
+	if handler != nil {
+		handler(levels)
 	}
+}
 
-	if b.onBlueInkChanged != nil {
-		b.onBlueInkChanged(b.blueInk)
+// colorDelta returns how much two colors differ, from 0 (identical) to 1,
+// going by the component that differs the most.
+func colorDelta(a, b color.RGBA) float64 {
+	diff := func(x, y uint8) float64 {
+		return math.Abs(float64(x) - float64(y))
 	}
+	return math.Max(math.Max(diff(a.R, b.R), diff(a.G, b.G)), math.Max(diff(a.B, b.B), diff(a.A, b.A))) / 255
 }
 
 func (b *InkBrush) DrainRate() (rate float64) {
@@ -254,27 +510,130 @@ func (b *InkBrush) SetDrainRate(rate float64) *InkBrush {
 	return b
 }
 
-func (b *InkBrush) RedInk() (level float64) {
+func (b *InkBrush) Smoothing() (enabled bool) {
+	b.stateMutex.Lock()
+	enabled = b.smoothing
+	b.stateMutex.Unlock()
+	return
+}
+
+// SetSmoothing sets whether strokes follow a curve through the mouse samples
+// rather than straight lines between them.
+func (b *InkBrush) SetSmoothing(enabled bool) *InkBrush {
+	b.stateMutex.Lock()
+	b.smoothing = enabled
+	b.stateMutex.Unlock()
+	return b
+}
+
+// ClearCanvas clears the canvas, along with the brush's copy of it, and
+// forgets the strokes that could be undone.  Clearing the canvas by itself
+// would leave the brush's copy as it was, to be painted back over the
+// cleared surface by the next stroke.
+func (b *InkBrush) ClearCanvas() {
+	b.stateMutex.Lock()
+	clear(b.canvasBuffer) // as the canvas clears its surface, to transparent
+	b.dirty = dirtyRect{}
+	b.stroke = nil
+	b.strokeRect = dirtyRect{}
+	clear(b.strokeTiles)
+	b.history.clear()
+	b.stateMutex.Unlock()
+
+	if canvas := b.Canvas(); canvas != nil {
+		canvas.Clear()
+	}
+}
+
+// Undo requests that the last stroke be undone, on the next update.
+func (b *InkBrush) Undo() {
+	b.stateMutex.Lock()
+	b.historyRequests = append(b.historyRequests, undoRequest)
+	b.stateMutex.Unlock()
+}
+
+// Redo requests that the last stroke undone be redone, on the next update.
+func (b *InkBrush) Redo() {
+	b.stateMutex.Lock()
+	b.historyRequests = append(b.historyRequests, redoRequest)
+	b.stateMutex.Unlock()
+}
+
+func (b *InkBrush) RefundInkOnUndo() (refund bool) {
+	b.stateMutex.Lock()
+	refund = b.refundInk
+	b.stateMutex.Unlock()
+	return
+}
+
+// SetRefundInkOnUndo sets whether undoing a stroke returns the ink it took to
+// the tanks (and redoing it takes the ink again), rather than the ink staying
+// spent.
+func (b *InkBrush) SetRefundInkOnUndo(refund bool) *InkBrush {
+	b.stateMutex.Lock()
+	b.refundInk = refund
+	b.stateMutex.Unlock()
+	return b
+}
+
+func (b *InkBrush) InkModel() (model InkModel) {
 	b.stateMutex.Lock()
-	level = b.redInk
+	model = b.model
 	b.stateMutex.Unlock()
 	return
 }
 
-func (b *InkBrush) GreenInk() (level float64) {
+// SetInkModel changes the brush's ink tanks to those of the given model,
+// filling them up.
+func (b *InkBrush) SetInkModel(model InkModel) *InkBrush {
+	b.stateMutex.Lock()
+	b.model = model
+	b.levels = make([]float64, len(model.Tanks()))
+	for i := range b.levels {
+		b.levels[i] = 1.0
+	}
+	b.strokeInk = make([]float64, len(b.levels))
+	b.refilling = false
+	handler := b.onInkModelChanged
+	b.stateMutex.Unlock()
+
+	if handler != nil {
+		handler(model)
+	}
+	b.dispatchEvents()
+	return b
+}
+
+// InkLevels returns the level of each tank of the ink model, from 0 to 1.
+func (b *InkBrush) InkLevels() []float64 {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	return append([]float64(nil), b.levels...)
+}
+
+// InkUsed returns how much ink has been used since the last call to
+// ResetInkUsed, in tanks, summed across every tank.
+func (b *InkBrush) InkUsed() (used float64) {
 	b.stateMutex.Lock()
-	level = b.greenInk
+	used = b.inkUsed
 	b.stateMutex.Unlock()
 	return
 }
 
-func (b *InkBrush) BlueInk() (level float64) {
+// InkCapacity returns how much ink the brush holds when full, in tanks.
+func (b *InkBrush) InkCapacity() (capacity float64) {
 	b.stateMutex.Lock()
-	level = b.blueInk
+	capacity = float64(len(b.levels))
 	b.stateMutex.Unlock()
 	return
 }
 
+func (b *InkBrush) ResetInkUsed() {
+	b.stateMutex.Lock()
+	b.inkUsed = 0
+	b.stateMutex.Unlock()
+}
+
 func (b *InkBrush) RefillInk() {
 	b.stateMutex.Lock()
 	b.refilling = true
@@ -283,24 +642,26 @@ func (b *InkBrush) RefillInk() {
 
 func (b *InkBrush) RefillInkInstantly() {
 	b.stateMutex.Lock()
-	b.redInk = 1.0
-	b.greenInk = 1.0
-	b.blueInk = 1.0
+	for i := range b.levels {
+		b.levels[i] = 1.0
+	}
 	b.refilling = false
 	b.stateMutex.Unlock()
 	b.dispatchEvents()
 }
 
-func (b *InkBrush) OnRedInkChanged(handler func(newInkLevel float64)) {
-	b.onRedInkChanged = handler
-}
-
-func (b *InkBrush) OnGreenInkChanged(handler func(newInkLevel float64)) {
-	b.onGreenInkChanged = handler
+// OnInkChanged sets the handler called with the level of each tank whenever
+// they change.
+func (b *InkBrush) OnInkChanged(handler func(newInkLevels []float64)) {
+	b.stateMutex.Lock()
+	b.onInkChanged = handler
+	b.stateMutex.Unlock()
 }
 
-func (b *InkBrush) OnBlueInkChanged(handler func(newInkLevel float64)) {
-	b.onBlueInkChanged = handler
+func (b *InkBrush) OnInkModelChanged(handler func(newInkModel InkModel)) {
+	b.stateMutex.Lock()
+	b.onInkModelChanged = handler
+	b.stateMutex.Unlock()
 }
 
 /******************************************************************************
@@ -310,13 +671,75 @@ func (b *InkBrush) OnBlueInkChanged(handler func(newInkLevel float64)) {
 func NewInkBrush() *InkBrush {
 	b := &InkBrush{
 		BasicBrush: *gfx.NewBasicBrush(),
-		redInk:     1.0,
-		greenInk:   1.0,
-		blueInk:    1.0,
-		drainRate:  2.5,
+		drainRate:  defaultDrainRate,
+		history:    strokeHistory{limit: maxUndoStrokes},
 	}
 
+	b.upload = b.uploadRegion
+
 	b.SetName("InkBrush")
-	b.OverrideUpdateCanvas(b.updateCanvas)
+	b.SetInkModel(RGBInkModel{})
 	return b
 }
+
+/******************************************************************************
+ dirtyRect
+******************************************************************************/
+
+// dirtyRect is a region of the canvas surface, in pixels, from min
+// (inclusive) to max (exclusive).  The zero value is empty.
+type dirtyRect struct {
+	minX, minY, maxX, maxY int
+}
+
+func newDirtyRect(minX, minY, maxX, maxY int) dirtyRect {
+	return dirtyRect{minX: minX, minY: minY, maxX: maxX, maxY: maxY}
+}
+
+func (r dirtyRect) empty() bool {
+	return r.minX >= r.maxX || r.minY >= r.maxY
+}
+
+func (r dirtyRect) width() int {
+	return r.maxX - r.minX
+}
+
+func (r dirtyRect) height() int {
+	return r.maxY - r.minY
+}
+
+func (r dirtyRect) union(other dirtyRect) dirtyRect {
+	switch {
+	case r.empty():
+		return other
+	case other.empty():
+		return r
+	}
+
+	return dirtyRect{
+		minX: min(r.minX, other.minX),
+		minY: min(r.minY, other.minY),
+		maxX: max(r.maxX, other.maxX),
+		maxY: max(r.maxY, other.maxY),
+	}
+}
+
+// intersect returns the part of the region within the other.
+func (r dirtyRect) intersect(other dirtyRect) dirtyRect {
+	return dirtyRect{
+		minX: max(r.minX, other.minX),
+		minY: max(r.minY, other.minY),
+		maxX: min(r.maxX, other.maxX),
+		maxY: min(r.maxY, other.maxY),
+	}
+}
+
+// clip returns the part of the region within a surface of the given size.
+func (r dirtyRect) clip(width, height int) dirtyRect {
+	return dirtyRect{
+		minX: max(r.minX, 0),
+		minY: max(r.minY, 0),
+		maxX: min(r.maxX, width),
+		maxY: min(r.maxY, height),
+	}
+}
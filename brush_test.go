@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/tonybillings/gfx"
+	"image/color"
+	"math"
+	"testing"
+)
+
+/******************************************************************************
+ Test Helpers
+******************************************************************************/
+
+// newTestInkBrush returns a brush painting into a white buffer of the given
+// size, as if synced with a canvas surface, with no window to size its drain
+// rate by.
+func newTestInkBrush(width, height int) *InkBrush {
+	b := NewInkBrush()
+	b.SetColor(gfx.Red)
+	b.initDrainRateMod()
+
+	b.canvasBuffer = make([]uint8, width*height*4)
+	for i := range b.canvasBuffer {
+		b.canvasBuffer[i] = 255
+	}
+	b.bufferWidth = width
+	return b
+}
+
+// paintTestStroke paints a stroke through the given samples, one per update,
+// as updateCanvas would, then ends it.
+func paintTestStroke(b *InkBrush, height, radius int, samples ...strokePoint) {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+
+	b.beginStroke()
+	for _, sample := range samples {
+		b.paintStroke(sample, b.bufferWidth, height, radius)
+	}
+	b.endStroke()
+}
+
+// lineSamples returns count samples from (x, y) to the right, step apart.
+func lineSamples(x, y, step float64, count int) []strokePoint {
+	samples := make([]strokePoint, count)
+	for i := range samples {
+		samples[i] = strokePoint{x: x + float64(i)*step, y: y}
+	}
+	return samples
+}
+
+// countPixels returns how many pixels of the brush's buffer are the color.
+func countPixels(b *InkBrush, c color.RGBA) (count int) {
+	for i := 0; i < len(b.canvasBuffer); i += 4 {
+		if b.canvasBuffer[i] == c.R && b.canvasBuffer[i+1] == c.G && b.canvasBuffer[i+2] == c.B && b.canvasBuffer[i+3] == c.A {
+			count++
+		}
+	}
+	return
+}
+
+/******************************************************************************
+ InkBrush Tests
+******************************************************************************/
+
+func TestInkBrushDrainsByAreaChanged(t *testing.T) {
+	const width, height, radius = 200, 100, 10
+	b := newTestInkBrush(width, height)
+
+	paintTestStroke(b, height, radius, lineSamples(20, 50, 40, 5)...)
+
+	// Red on white changes every pixel painted completely, draining only the
+	// red tank, by the drain rate for each of a million pixels (as there is
+	// no window, the size of which is assumed).
+	if painted := countPixels(b, gfx.Red); painted != 3421 {
+		t.Fatalf("got %d pixels painted, want 3421", painted)
+	}
+	want := 3421 * defaultDrainRate / 1e6 // about 0.0269
+	if math.Abs(b.InkUsed()-want) > 1e-9 {
+		t.Errorf("got %.6f tanks used, want %.6f", b.InkUsed(), want)
+	}
+
+	levels := b.InkLevels()
+	if math.Abs(1-levels[0]-want) > 1e-9 || levels[1] != 1 || levels[2] != 1 {
+		t.Errorf("got levels %v, want only red drained by %.6f", levels, want)
+	}
+
+	// Going over the same stroke changes nothing and so takes no ink.
+	paintTestStroke(b, height, radius, lineSamples(20, 50, 40, 5)...)
+	if math.Abs(b.InkUsed()-want) > 1e-9 {
+		t.Errorf("got %.6f tanks used after repainting, want %.6f", b.InkUsed(), want)
+	}
+}
+
+func TestInkBrushDrainMatchesPerStampDrain(t *testing.T) {
+	const width, height, radius, frames = 400, 100, 10, 60
+	b := newTestInkBrush(width, height)
+
+	// A stroke moving by half the head's radius each frame, for which
+	// defaultDrainRate was tuned to take as much ink as when every stamp
+	// drained its whole area at a rate of 2.5.
+	paintTestStroke(b, height, radius, lineSamples(20, 50, radius/2, frames)...)
+
+	perStamp := frames * math.Pi * radius * radius * 2.5 / 1e6
+	if ratio := b.InkUsed() / perStamp; ratio < .9 || ratio > 1.1 {
+		t.Errorf("got %.6f tanks used, %.2f times the %.6f of the per-stamp drain", b.InkUsed(), ratio, perStamp)
+	}
+}
+
+func TestInkBrushUndoRedoOrdering(t *testing.T) {
+	const width, height, radius = 200, 100, 10
+	b := newTestInkBrush(width, height)
+	b.SetRefundInkOnUndo(true)
+
+	paintTestStroke(b, height, radius, lineSamples(20, 25, 40, 5)...)
+	first := b.InkUsed()
+	paintTestStroke(b, height, radius, lineSamples(20, 75, 40, 5)...)
+	stroke := countPixels(b, gfx.Red) / 2
+
+	b.Undo()
+	b.applyHistoryRequests()
+	if painted := countPixels(b, gfx.Red); painted != stroke {
+		t.Fatalf("got %d pixels painted after undo, want %d", painted, stroke)
+	}
+	if math.Abs(b.InkUsed()-first) > 1e-9 {
+		t.Errorf("got %.6f tanks used after undo, want %.6f refunded to %.6f", b.InkUsed(), b.InkUsed()-first, first)
+	}
+
+	// A stroke started in the same update as the undo starts from the stroke
+	// undone, and takes the place of it to redo.
+	b.stateMutex.Lock()
+	b.beginStroke()
+	b.paintStroke(strokePoint{x: 100, y: 75}, width, height, radius)
+	b.endStroke()
+	b.stateMutex.Unlock()
+
+	if len(b.history.undo) != 2 || len(b.history.redo) != 0 {
+		t.Fatalf("got %d strokes to undo and %d to redo, want 2 and 0", len(b.history.undo), len(b.history.redo))
+	}
+	if stamped := countPixels(b, gfx.Red) - stroke; stamped <= 0 || stamped >= stroke {
+		t.Fatalf("got %d pixels painted by the new stroke, want a single stamp's worth", stamped)
+	}
+
+	// Undo and redo apply in the order requested.
+	b.Undo()
+	b.Undo()
+	b.Redo()
+	b.applyHistoryRequests()
+	if painted := countPixels(b, gfx.Red); painted != stroke {
+		t.Errorf("got %d pixels painted after undo, undo, redo, want %d", painted, stroke)
+	}
+	if math.Abs(b.InkUsed()-first) > 1e-9 {
+		t.Errorf("got %.6f tanks used after undo, undo, redo, want %.6f", b.InkUsed(), first)
+	}
+
+	b.Undo()
+	b.applyHistoryRequests()
+	if painted := countPixels(b, gfx.Red); painted != 0 || b.InkUsed() > 1e-9 {
+		t.Errorf("got %d pixels painted and %.6f tanks used after undoing all, want none", painted, b.InkUsed())
+	}
+}
+
+func TestInkBrushUndoRestoresPaintedOver(t *testing.T) {
+	const width, height, radius = 200, 100, 10
+	b := newTestInkBrush(width, height)
+
+	paintTestStroke(b, height, radius, lineSamples(20, 50, 40, 5)...)
+	painted := append([]uint8(nil), b.canvasBuffer...)
+
+	// A blue stroke across the red one, reaching tiles the red one did not.
+	b.SetColor(gfx.Blue)
+	b.stateMutex.Lock()
+	b.beginStroke()
+	for y := 5.; y <= 95; y += 10 {
+		b.paintStroke(strokePoint{x: 100, y: y}, width, height, radius)
+	}
+	b.endStroke()
+	b.stateMutex.Unlock()
+
+	b.Undo()
+	b.applyHistoryRequests()
+	if !bytes.Equal(b.canvasBuffer, painted) {
+		t.Fatal("undoing the blue stroke did not restore the canvas as the red stroke left it")
+	}
+
+	b.Redo()
+	b.applyHistoryRequests()
+	if countPixels(b, gfx.Blue) == 0 {
+		t.Error("redoing the blue stroke painted nothing blue")
+	}
+}
+
+func TestInkBrushClearCanvas(t *testing.T) {
+	const width, height, radius = 200, 100, 10
+	b := newTestInkBrush(width, height)
+
+	paintTestStroke(b, height, radius, lineSamples(20, 50, 40, 5)...)
+	b.ClearCanvas()
+
+	if transparent := countPixels(b, color.RGBA{}); transparent != width*height {
+		t.Errorf("got %d pixels cleared, want all %d", transparent, width*height)
+	}
+	if !b.dirty.empty() || len(b.history.undo) != 0 {
+		t.Errorf("got dirty region %v and %d strokes to undo after clearing, want none", b.dirty, len(b.history.undo))
+	}
+
+	b.Undo()
+	b.applyHistoryRequests()
+	if transparent := countPixels(b, color.RGBA{}); transparent != width*height {
+		t.Errorf("got %d pixels cleared after undo, want all %d", transparent, width*height)
+	}
+}
+
+/******************************************************************************
+ InkBrush Benchmarks
+******************************************************************************/
+
+// BenchmarkInkBrushPaintStroke measures an update of a stroke being painted,
+// and a whole stroke from start to upload, both of which should follow the
+// size of the brush rather than that of the canvas.
+func BenchmarkInkBrushPaintStroke(b *testing.B) {
+	canvasSizes := []struct{ width, height int }{{640, 360}, {1425, 750}, {2880, 1620}}
+	brushSizes := []float32{.005, .055, .105} // the range of the brush size slider
+
+	for _, canvas := range canvasSizes {
+		// The same stroke on every canvas, in alternating colors so that
+		// every stroke paints, uploaded by copying out the region painted, as
+		// the driver would.
+		b.Run(fmt.Sprintf("%dx%d/stroke", canvas.width, canvas.height), func(b *testing.B) {
+			const radius = 20
+			brush := newTestInkBrush(canvas.width, canvas.height)
+			brush.SetDrainRate(0)
+
+			uploaded := 0
+			brush.upload = func(buffer []uint8, width int, rect dirtyRect) {
+				uploaded += len(readRegion(buffer, width, rect))
+			}
+
+			samples := lineSamples(radius, radius, radius, 10)
+			colors := []color.RGBA{gfx.Red, gfx.Blue}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				brush.SetColor(colors[i%2])
+
+				brush.stateMutex.Lock()
+				brush.beginStroke()
+				for _, sample := range samples {
+					brush.paintStroke(sample, canvas.width, canvas.height, radius)
+				}
+				brush.endStroke()
+				brush.stateMutex.Unlock()
+
+				brush.flushCanvas()
+			}
+			b.ReportMetric(float64(uploaded)/float64(b.N), "uploaded-B/op")
+		})
+
+		for _, size := range brushSizes {
+			radius := int(size * float32(canvas.width) * .5)
+			b.Run(fmt.Sprintf("%dx%d/radius=%d", canvas.width, canvas.height, radius), func(b *testing.B) {
+				brush := newTestInkBrush(canvas.width, canvas.height)
+				brush.SetDrainRate(0)
+
+				// Samples a head's radius apart, back and forth across the
+				// canvas in alternating colors, so that every update paints.
+				samples := lineSamples(float64(radius), float64(canvas.height)/2, float64(radius),
+					(canvas.width-2*radius)/radius)
+				colors := []color.RGBA{gfx.Red, gfx.Blue}
+
+				brush.stateMutex.Lock()
+				defer brush.stateMutex.Unlock()
+				brush.beginStroke()
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					pass, index := i/len(samples), i%len(samples)
+					if pass%2 == 1 {
+						index = len(samples) - 1 - index
+					}
+					if index == 0 || index == len(samples)-1 {
+						brush.SetColor(colors[pass%2])
+					}
+					brush.paintStroke(samples[index], canvas.width, canvas.height, radius)
+				}
+			})
+		}
+	}
+}
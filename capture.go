@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/go-gl/gl/v4.1-core/gl"
+	"github.com/tonybillings/gfx"
+	"image"
+	"image/png"
+	"sync"
+	"time"
+)
+
+/******************************************************************************
+ CanvasCapture
+******************************************************************************/
+
+// CanvasCapture takes snapshots of a canvas without going through the file
+// system.  It must be added as a child of the canvas, as the surface texture
+// can only be read on the render thread: requests are queued by Capture and
+// served on the next Draw, with the pixels then being flipped, composited
+// with the canvas background and encoded on another goroutine.  Note that
+// the canvas is only drawn while visible, so Capture will block while the
+// canvas is hidden.
+type CanvasCapture struct {
+	gfx.WindowObjectBase
+
+	canvas  *gfx.Canvas
+	pending []chan *Snapshot
+
+	stateMutex sync.Mutex
+}
+
+// Snapshot is the state of a canvas at a point in time, both as an image and
+// PNG-encoded, which is what a Guesser expects.
+type Snapshot struct {
+	Image *image.RGBA
+	PNG   []byte
+	Time  time.Time
+}
+
+/******************************************************************************
+ DrawableObject Implementation
+******************************************************************************/
+
+func (c *CanvasCapture) Draw(_ int64) (ok bool) {
+	if !c.Initialized() {
+		return false
+	}
+
+	c.stateMutex.Lock()
+	pending := c.pending
+	c.pending = nil
+	c.stateMutex.Unlock()
+
+	if len(pending) == 0 {
+		return true
+	}
+
+	surface := c.canvas.Surface()
+	width := surface.Width()
+	height := surface.Height()
+	buffer := make([]uint8, width*height*4)
+
+	gl.BindTexture(gl.TEXTURE_2D, surface.GlName())
+	gl.GetTexImage(gl.TEXTURE_2D, 0, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(&buffer[0]))
+	gl.BindTexture(gl.TEXTURE_2D, 0)
+
+	go func() {
+		snapshot := c.newSnapshot(buffer, width, height)
+		for _, reply := range pending {
+			reply <- snapshot
+		}
+	}()
+
+	return true
+}
+
+/******************************************************************************
+ CanvasCapture Functions
+******************************************************************************/
+
+func (c *CanvasCapture) newSnapshot(buffer []uint8, width, height int) *Snapshot {
+	bgColor := c.canvas.FillColor()
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width*4 + x*4
+			j := (height-y-1)*width*4 + x*4 // textures are stored bottom-up
+			if buffer[j+3] == 0 {
+				img.Pix[i+0] = bgColor.R
+				img.Pix[i+1] = bgColor.G
+				img.Pix[i+2] = bgColor.B
+				img.Pix[i+3] = bgColor.A
+			} else {
+				copy(img.Pix[i:i+4], buffer[j:j+4])
+			}
+		}
+	}
+
+	var pngBuffer bytes.Buffer
+	if err := png.Encode(&pngBuffer, img); err != nil {
+		panic(fmt.Errorf("error encoding snapshot: %w", err)) // only fails on invalid images
+	}
+
+	return &Snapshot{
+		Image: img,
+		PNG:   pngBuffer.Bytes(),
+		Time:  time.Now(),
+	}
+}
+
+// Capture returns a snapshot of the canvas as of the next frame.
+func (c *CanvasCapture) Capture(ctx context.Context) (*Snapshot, error) {
+	reply := make(chan *Snapshot, 1)
+
+	c.stateMutex.Lock()
+	c.pending = append(c.pending, reply)
+	c.stateMutex.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case snapshot := <-reply:
+		return snapshot, nil
+	}
+}
+
+/******************************************************************************
+ New CanvasCapture Function
+******************************************************************************/
+
+func NewCanvasCapture(canvas *gfx.Canvas) *CanvasCapture {
+	c := &CanvasCapture{
+		WindowObjectBase: *gfx.NewWindowObject(),
+		canvas:           canvas,
+	}
+
+	c.SetName("CanvasCapture")
+
+	return c
+}
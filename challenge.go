@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/tonybillings/pictionary-gpt/matcher"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/******************************************************************************
+ Difficulty
+******************************************************************************/
+
+type Difficulty int
+
+const (
+	Easy Difficulty = iota + 1
+	Normal
+	Hard
+)
+
+func (d Difficulty) String() string {
+	switch d {
+	case Easy:
+		return "Easy"
+	case Normal:
+		return "Normal"
+	case Hard:
+		return "Hard"
+	default:
+		return fmt.Sprintf("Difficulty(%d)", int(d))
+	}
+}
+
+func parseDifficulty(text string) (Difficulty, error) {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "easy":
+		return Easy, nil
+	case "normal":
+		return Normal, nil
+	case "hard":
+		return Hard, nil
+	default:
+		return 0, fmt.Errorf("unknown difficulty: %s", text)
+	}
+}
+
+func (d Difficulty) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+func (d *Difficulty) UnmarshalText(text []byte) (err error) {
+	*d, err = parseDifficulty(string(text))
+	return
+}
+
+/******************************************************************************
+ Challenge
+******************************************************************************/
+
+// Challenge is what the player is asked to draw: an Object that should be
+// painted (mostly) using the given Color, like 'Red ball'.
+type Challenge struct {
+	Color      string
+	Object     string
+	Difficulty Difficulty
+}
+
+func (c *Challenge) String() string {
+	return c.Color + " " + c.Object
+}
+
+/******************************************************************************
+ ChallengeGenerator
+******************************************************************************/
+
+// ChallengeGenerator implementations produce a new Challenge for the given
+// difficulty, avoiding the objects found in history (objects used in
+// previous games).  Implementations must be safe to call from a goroutine
+// other than the one running the render loop.
+type ChallengeGenerator interface {
+	NextChallenge(ctx context.Context, difficulty Difficulty, history []string) (*Challenge, error)
+}
+
+/******************************************************************************
+ WordListChallengeGenerator
+******************************************************************************/
+
+// WordListChallengeGenerator picks challenges at random from a fixed list,
+// preferring those whose object is not found in the history.  Once every
+// object for a difficulty has been used, objects will start to repeat.
+type WordListChallengeGenerator struct {
+	challenges []*Challenge
+	rng        *rand.Rand
+
+	stateMutex sync.Mutex
+}
+
+func (g *WordListChallengeGenerator) NextChallenge(_ context.Context, difficulty Difficulty, history []string) (*Challenge, error) {
+	g.stateMutex.Lock()
+	defer g.stateMutex.Unlock()
+
+	used := make(map[string]bool, len(history))
+	for _, object := range history {
+		used[strings.ToLower(object)] = true
+	}
+
+	var candidates, unused []*Challenge
+	for _, c := range g.challenges {
+		if c.Difficulty != difficulty {
+			continue
+		}
+		candidates = append(candidates, c)
+		if !used[strings.ToLower(c.Object)] {
+			unused = append(unused, c)
+		}
+	}
+
+	if len(unused) > 0 {
+		candidates = unused
+	}
+
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no challenges available for difficulty %s", difficulty)
+	}
+
+	challenge := *candidates[g.rng.Intn(len(candidates))]
+	return &challenge, nil
+}
+
+// NewWordListChallengeGenerator reads challenges from the given file, which
+// must contain one challenge per line in the form '<Difficulty> <Color>
+// <Object>', for example 'Hard Pink football field'.  Blank lines and lines
+// starting with '#' are ignored.  Colors must be allowed by the word bank for
+// the difficulty of the challenge.
+func NewWordListChallengeGenerator(path string, bank *WordBank) (*WordListChallengeGenerator, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening word list: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	var challenges []*Challenge
+	scanner := bufio.NewScanner(file)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("word list line %d: expected '<Difficulty> <Color> <Object>'", lineNum)
+		}
+
+		difficulty, e := parseDifficulty(fields[0])
+		if e != nil {
+			return nil, fmt.Errorf("word list line %d: %w", lineNum, e)
+		}
+
+		color, ok := bank.AllowedColor(difficulty, fields[1])
+		if !ok {
+			return nil, fmt.Errorf("word list line %d: color %s is not allowed for difficulty %s", lineNum, fields[1], difficulty)
+		}
+
+		challenges = append(challenges, &Challenge{
+			Color:      color,
+			Object:     strings.Join(fields[2:], " "),
+			Difficulty: difficulty,
+		})
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading word list: %w", err)
+	}
+
+	if len(challenges) == 0 {
+		return nil, errors.New("word list contains no challenges")
+	}
+
+	return &WordListChallengeGenerator{
+		challenges: challenges,
+		rng:        rand.New(rand.NewSource(time.Now().UnixNano())),
+	}, nil
+}
+
+/******************************************************************************
+ UniqueChallengeGenerator
+******************************************************************************/
+
+// UniqueChallengeGenerator rejects challenges whose object is found in the
+// history, rather than trusting the generator to avoid them, and asks again
+// up to the given number of attempts in all, with the rejected objects added
+// to the history.  Objects are compared word by word after stemming, so that
+// 'Cats' repeats 'cat'.  Should every attempt produce a repeat, the last one
+// is accepted, as a repeat is better than no game at all.
+type UniqueChallengeGenerator struct {
+	generator ChallengeGenerator
+	attempts  int
+}
+
+func (g *UniqueChallengeGenerator) NextChallenge(ctx context.Context, difficulty Difficulty,
+	history []string) (challenge *Challenge, err error) {
+	used := make(map[string]bool, len(history))
+	for _, object := range history {
+		used[objectKey(object)] = true
+	}
+
+	history = append([]string(nil), history...)
+	for attempt := 1; ; attempt++ {
+		challenge, err = g.generator.NextChallenge(ctx, difficulty, history)
+		if err != nil || !used[objectKey(challenge.Object)] || attempt >= g.attempts {
+			return
+		}
+
+		history = append(history, challenge.Object)
+	}
+}
+
+func NewUniqueChallengeGenerator(generator ChallengeGenerator, attempts int) *UniqueChallengeGenerator {
+	return &UniqueChallengeGenerator{
+		generator: generator,
+		attempts:  attempts,
+	}
+}
+
+/******************************************************************************
+ ChallengeGenerator Functions
+******************************************************************************/
+
+// objectKey normalizes an object for comparison with others.
+func objectKey(object string) string {
+	words := matcher.Tokenize(object)
+	for i, word := range words {
+		words[i] = matcher.Stem(word)
+	}
+	return strings.Join(words, " ")
+}
+
+func newChallengeGenerator(bank *WordBank, scheduler *RequestScheduler) (ChallengeGenerator, error) {
+	var generator ChallengeGenerator
+
+	switch cfg.Challenge.Backend {
+	case "gpt":
+		client, err := newGptClient(&cfg.OpenAI, scheduler.Transport(http.DefaultTransport))
+		if err != nil {
+			return nil, err
+		}
+		// Repeats are caught by the generator itself, within the same
+		// attempts as responses that break the rules.
+		return NewGptChallengeGenerator(client, cfg.OpenAI.Model, bank, scheduler,
+			cfg.Challenge.PromptHistory, cfg.Challenge.Attempts), nil
+	case "wordlist":
+		wordListGenerator, err := NewWordListChallengeGenerator(cfg.Challenge.WordListFile, bank)
+		if err != nil {
+			return nil, err
+		}
+		generator = wordListGenerator
+	case "wordbank":
+		generator = NewWordBankChallengeGenerator(bank, cfg.Challenge.Seed)
+	default:
+		return nil, fmt.Errorf("unknown challenge backend: %s", cfg.Challenge.Backend)
+	}
+
+	return NewUniqueChallengeGenerator(generator, cfg.Challenge.Attempts), nil
+}
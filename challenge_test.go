@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"github.com/tonybillings/pictionary-gpt/words"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+/******************************************************************************
+ Test Helpers
+******************************************************************************/
+
+// scriptedChallengeGenerator returns the given objects in order, recording
+// the history it was given for each call.
+type scriptedChallengeGenerator struct {
+	objects   []string
+	histories [][]string
+}
+
+func (g *scriptedChallengeGenerator) NextChallenge(_ context.Context, difficulty Difficulty, history []string) (*Challenge, error) {
+	g.histories = append(g.histories, append([]string(nil), history...))
+	object := g.objects[0]
+	if len(g.objects) > 1 {
+		g.objects = g.objects[1:]
+	}
+	return &Challenge{Color: "Red", Object: object, Difficulty: difficulty}, nil
+}
+
+func loadTestWordBank(t testing.TB) *WordBank {
+	t.Helper()
+	bank, err := LoadWordBank(words.Assets, defaultWordBankFile)
+	if err != nil {
+		t.Fatalf("error loading word bank: %v", err)
+	}
+	return bank
+}
+
+func nextChallenges(t *testing.T, generator ChallengeGenerator, difficulty Difficulty, count int, history []string) []string {
+	t.Helper()
+	challenges := make([]string, count)
+	for i := range challenges {
+		challenge, err := generator.NextChallenge(context.Background(), difficulty, history)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		challenges[i] = challenge.String()
+	}
+	return challenges
+}
+
+/******************************************************************************
+ WordListChallengeGenerator Tests
+******************************************************************************/
+
+func TestWordListChallengeGenerator(t *testing.T) {
+	bank := loadTestWordBank(t)
+	file := path.Join(t.TempDir(), "challenges.txt")
+	list := "# comment\n\nEasy red ball\nEasy Blue kite\nHard Pink football field\n"
+	if err := os.WriteFile(file, []byte(list), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	generator, err := NewWordListChallengeGenerator(file, bank)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, challenge := range nextChallenges(t, generator, Hard, 5, nil) {
+		if challenge != "Pink football field" {
+			t.Errorf("got %q, want %q", challenge, "Pink football field")
+		}
+	}
+
+	for _, challenge := range nextChallenges(t, generator, Easy, 5, []string{"BALL"}) {
+		if challenge != "Blue kite" {
+			t.Errorf("got %q, want the unused %q", challenge, "Blue kite")
+		}
+	}
+
+	for _, bad := range []string{"Easy ball\n", "Impossible Red ball\n", "Hard Red ball\n", "# nothing\n"} {
+		if err = os.WriteFile(file, []byte(bad), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = NewWordListChallengeGenerator(file, bank); err == nil {
+			t.Errorf("word list %q: expected an error", bad)
+		}
+	}
+}
+
+/******************************************************************************
+ UniqueChallengeGenerator Tests
+******************************************************************************/
+
+func TestObjectKey(t *testing.T) {
+	tests := []struct {
+		a, b string
+		same bool
+	}{
+		{"cat", "Cats", true},
+		{"box", "boxes", true},
+		{"cherry", "Cherries", true},
+		{"mouse", "mice", true},
+		{"Football field", "football fields", true},
+		{"the moon", "Moon", true},
+		{"cat", "catfish", false},
+		{"house", "horse", false},
+	}
+
+	for _, test := range tests {
+		if same := objectKey(test.a) == objectKey(test.b); same != test.same {
+			t.Errorf("objectKey(%q) == objectKey(%q) is %v, want %v", test.a, test.b, same, test.same)
+		}
+	}
+}
+
+func TestUniqueChallengeGeneratorRejectsStemmedRepeats(t *testing.T) {
+	scripted := &scriptedChallengeGenerator{objects: []string{"Cats", "boxes", "dog"}}
+	generator := NewUniqueChallengeGenerator(scripted, 3)
+
+	challenge, err := generator.NextChallenge(context.Background(), Easy, []string{"cat", "box"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if challenge.Object != "dog" {
+		t.Errorf("got %q, want %q", challenge.Object, "dog")
+	}
+
+	// Each rejected object is added to the history given on the next attempt.
+	want := []string{"cat,box", "cat,box,Cats", "cat,box,Cats,boxes"}
+	for i, history := range scripted.histories {
+		if got := strings.Join(history, ","); got != want[i] {
+			t.Errorf("attempt %d: got history %q, want %q", i+1, got, want[i])
+		}
+	}
+}
+
+func TestUniqueChallengeGeneratorAcceptsRepeatWhenOutOfAttempts(t *testing.T) {
+	scripted := &scriptedChallengeGenerator{objects: []string{"Cats", "cat", "dog"}}
+	generator := NewUniqueChallengeGenerator(scripted, 2)
+
+	challenge, err := generator.NextChallenge(context.Background(), Easy, []string{"cat"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if challenge.Object != "cat" {
+		t.Errorf("got %q, want the last repeat %q", challenge.Object, "cat")
+	}
+	if len(scripted.histories) != 2 {
+		t.Errorf("got %d attempts, want 2", len(scripted.histories))
+	}
+}
+
+func TestUniqueChallengeGeneratorWithWordBank(t *testing.T) {
+	bank := loadTestWordBank(t)
+	entries := bank.Entries(Easy)
+
+	// Pluralized history still counts as used, which the word bank generator
+	// alone does not know, so the unique generator must catch the repeats.
+	var history []string
+	for _, entry := range entries[1:] {
+		history = append(history, entry.Object+"s")
+	}
+
+	generator := NewUniqueChallengeGenerator(NewWordBankChallengeGenerator(bank, 99), len(entries)+1)
+	for i := 0; i < 10; i++ {
+		challenge, err := generator.NextChallenge(context.Background(), Easy, history)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if challenge.Object != entries[0].Object {
+			t.Errorf("got %q, want the only unused object %q", challenge.Object, entries[0].Object)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"sync"
+)
+
+const (
+	changeGridSize      = 32 // snapshots are compared as a grid of this many cells squared
+	changeCellTolerance = 4  // how much a cell's average color may change without counting
+)
+
+/******************************************************************************
+ ChangeDetector
+******************************************************************************/
+
+// ChangeDetector decides whether a snapshot differs enough from the last one
+// to be worth guessing at.  Rather than comparing pixels directly, snapshots
+// are reduced to a grid of average colors, a simple perceptual hash that is
+// cheap to compare and unaffected by changes too small to alter a guess.  A
+// snapshot is considered changed when the fraction of grid cells whose color
+// changed exceeds the threshold, so a threshold of zero means any change and a
+// negative threshold means every snapshot.
+type ChangeDetector struct {
+	threshold float64
+	last      []uint8
+
+	sent    int
+	skipped int
+
+	stateMutex sync.Mutex
+}
+
+// Changed returns true if the snapshot should be sent to the guesser, in
+// which case it becomes the snapshot that the next one is compared against.
+func (d *ChangeDetector) Changed(snapshot *Snapshot) bool {
+	hash := perceptualHash(snapshot.Image)
+
+	d.stateMutex.Lock()
+	defer d.stateMutex.Unlock()
+
+	if d.last != nil && d.difference(hash) <= d.threshold {
+		d.skipped++
+		return false
+	}
+
+	d.last = hash
+	d.sent++
+	return true
+}
+
+// difference must be called with the state mutex held.
+func (d *ChangeDetector) difference(hash []uint8) float64 {
+	changed := 0
+	for i := 0; i < len(hash); i += 3 {
+		for c := 0; c < 3; c++ {
+			delta := int(hash[i+c]) - int(d.last[i+c])
+			if delta > changeCellTolerance || delta < -changeCellTolerance {
+				changed++
+				break
+			}
+		}
+	}
+	return float64(changed) / float64(len(hash)/3)
+}
+
+// Reset forgets the last snapshot, so that the next one will be sent
+// regardless, such as when the guess at the last one failed.
+func (d *ChangeDetector) Reset() {
+	d.stateMutex.Lock()
+	d.last = nil
+	d.stateMutex.Unlock()
+}
+
+// Stats returns how many snapshots were sent to the guesser and how many were
+// skipped for being unchanged, the latter being the number of calls saved.
+func (d *ChangeDetector) Stats() (sent, skipped int) {
+	d.stateMutex.Lock()
+	defer d.stateMutex.Unlock()
+	return d.sent, d.skipped
+}
+
+func (d *ChangeDetector) String() string {
+	sent, skipped := d.Stats()
+	return fmt.Sprintf("guesses: %d sent, %d skipped as unchanged", sent, skipped)
+}
+
+/******************************************************************************
+ ChangeDetector Functions
+******************************************************************************/
+
+// perceptualHash reduces the image to a grid of average RGB colors.
+func perceptualHash(img *image.RGBA) []uint8 {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	sums := make([]int, changeGridSize*changeGridSize*4) // r, g, b, count
+
+	for y := 0; y < height; y++ {
+		row := y * changeGridSize / height
+		for x := 0; x < width; x++ {
+			cell := (row*changeGridSize + x*changeGridSize/width) * 4
+			i := img.PixOffset(bounds.Min.X+x, bounds.Min.Y+y)
+			sums[cell] += int(img.Pix[i])
+			sums[cell+1] += int(img.Pix[i+1])
+			sums[cell+2] += int(img.Pix[i+2])
+			sums[cell+3]++
+		}
+	}
+
+	hash := make([]uint8, changeGridSize*changeGridSize*3)
+	for cell := 0; cell < changeGridSize*changeGridSize; cell++ {
+		if count := sums[cell*4+3]; count > 0 {
+			hash[cell*3] = uint8(sums[cell*4] / count)
+			hash[cell*3+1] = uint8(sums[cell*4+1] / count)
+			hash[cell*3+2] = uint8(sums[cell*4+2] / count)
+		}
+	}
+
+	return hash
+}
+
+/******************************************************************************
+ New ChangeDetector Function
+******************************************************************************/
+
+func NewChangeDetector(threshold float64) *ChangeDetector {
+	return &ChangeDetector{
+		threshold: threshold,
+	}
+}
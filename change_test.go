@@ -0,0 +1,127 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+/******************************************************************************
+ Test Helpers
+******************************************************************************/
+
+func newTestSnapshot(width, height int) *Snapshot {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+	return &Snapshot{Image: img}
+}
+
+// paintCells paints the first count cells of the grid, in rows, black.
+func paintCells(snapshot *Snapshot, count int) {
+	bounds := snapshot.Image.Bounds()
+	cellWidth, cellHeight := bounds.Dx()/changeGridSize, bounds.Dy()/changeGridSize
+	for cell := 0; cell < count; cell++ {
+		x, y := cell%changeGridSize*cellWidth, cell/changeGridSize*cellHeight
+		draw.Draw(snapshot.Image, image.Rect(x, y, x+cellWidth, y+cellHeight), image.Black, image.Point{}, draw.Src)
+	}
+}
+
+/******************************************************************************
+ ChangeDetector Tests
+******************************************************************************/
+
+func TestChangeDetectorAnyChange(t *testing.T) {
+	detector := NewChangeDetector(0)
+	snapshot := newTestSnapshot(320, 320)
+
+	if !detector.Changed(snapshot) {
+		t.Error("first snapshot was skipped")
+	}
+	if detector.Changed(snapshot) {
+		t.Error("unchanged snapshot was sent")
+	}
+
+	// A pixel alone barely changes the average color of its cell.
+	snapshot.Image.Set(5, 5, color.Black)
+	if detector.Changed(snapshot) {
+		t.Error("snapshot changed by a pixel was sent")
+	}
+
+	paintCells(snapshot, 1)
+	if !detector.Changed(snapshot) {
+		t.Error("snapshot changed by a cell was skipped")
+	}
+
+	// After a reset, as at the start of a round, the next one is sent anyway.
+	detector.Reset()
+	if !detector.Changed(snapshot) {
+		t.Error("snapshot after reset was skipped")
+	}
+
+	if sent, skipped := detector.Stats(); sent != 3 || skipped != 2 {
+		t.Errorf("got %d sent and %d skipped, want 3 and 2", sent, skipped)
+	}
+}
+
+func TestChangeDetectorThreshold(t *testing.T) {
+	const cells = changeGridSize * changeGridSize
+	detector := NewChangeDetector(.1)
+	snapshot := newTestSnapshot(320, 320)
+	detector.Changed(snapshot)
+
+	paintCells(snapshot, cells/10)
+	if detector.Changed(snapshot) {
+		t.Error("snapshot changed by less than the threshold was sent")
+	}
+
+	// Changes add up against the last snapshot sent, not the last skipped.
+	paintCells(snapshot, cells/10+1)
+	if !detector.Changed(snapshot) {
+		t.Error("snapshot changed by more than the threshold was skipped")
+	}
+}
+
+func TestChangeDetectorAlwaysGuess(t *testing.T) {
+	detector := NewChangeDetector(-1)
+	snapshot := newTestSnapshot(320, 320)
+
+	for i := 0; i < 3; i++ {
+		if !detector.Changed(snapshot) {
+			t.Errorf("snapshot %d was skipped with a negative threshold", i+1)
+		}
+	}
+}
+
+func TestPerceptualHash(t *testing.T) {
+	// Cells of a size not dividing the image evenly still cover all of it,
+	// up to the far corner.
+	snapshot := newTestSnapshot(100, 70)
+	snapshot.Image.Set(99, 69, color.RGBA{R: 255, A: 255})
+	snapshot.Image.Set(0, 0, color.Black)
+
+	hash := perceptualHash(snapshot.Image)
+	if len(hash) != changeGridSize*changeGridSize*3 {
+		t.Fatalf("got a hash of %d values, want %d", len(hash), changeGridSize*changeGridSize*3)
+	}
+
+	first, last := hash[:3], hash[len(hash)-3:]
+	if first[0] >= 255 || first[0] != first[1] || first[1] != first[2] {
+		t.Errorf("got first cell %v, want a gray darker than white", first)
+	}
+	if last[0] != 255 || last[1] >= 255 || last[1] != last[2] {
+		t.Errorf("got last cell %v, want a pink", last)
+	}
+	for i := 3; i < len(hash)-3; i++ {
+		if hash[i] != 255 {
+			t.Fatalf("got value %d at %d, want only the corner cells changed", hash[i], i)
+		}
+	}
+
+	// An image smaller than the grid leaves some cells empty, rather than
+	// failing.
+	hash = perceptualHash(newTestSnapshot(8, 8).Image)
+	if len(hash) != changeGridSize*changeGridSize*3 || hash[0] != 255 || hash[3] != 0 {
+		t.Errorf("got cells %v for an 8x8 image, want white cells with empty ones between", hash[:6])
+	}
+}
@@ -1,23 +1,489 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/sashabaranov/go-openai"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
 )
 
 const (
-	windowTitle       = "Pictionary GPT"
-	windowWidth       = 1900 // best to set to near/at native resolution
-	windowHeight      = 1000 // best to set to near/at native resolution
-	tempDirectory     = "/tmp/pictionary"
-	timerCountdownSec = 5
-	timerEasySec      = 60
-	timerNormalSec    = 45
-	timerHardSec      = 30
+	defaultConfigFile  = "pictionary.yaml"
+	configFileEnvVar   = "PICTIONARY_CONFIG"
+	configEnvVarPrefix = "PICTIONARY_"
 )
 
-const ( // advanced settings
-	targetFramerate     = 999 // effectively disable framerate-limiting
-	vSyncEnabled        = false
-	gptGuessIntervalSec = 5
-	gptGuessAbility     = openai.ImageURLDetailLow
-)
+// cfg holds the settings in effect, which are loaded at startup by main().
+var cfg = defaultConfig()
+
+/******************************************************************************
+ Config
+******************************************************************************/
+
+// Config holds every runtime setting.  Settings are layered, with each layer
+// overriding the previous: the defaults below, then the YAML config file, then
+// environment variables and finally command-line flags.
+type Config struct {
+	Window     WindowConfig     `yaml:"window"`
+	Brush      BrushConfig      `yaml:"brush"`
+	Timer      TimerConfig      `yaml:"timer"`
+	Guesser    GuesserConfig    `yaml:"guesser"`
+	Challenge  ChallengeConfig  `yaml:"challenge"`
+	OpenAI     GptConfig        `yaml:"openai"`
+	Retry      RetryPolicy      `yaml:"retry"`
+	Scheduler  SchedulerConfig  `yaml:"scheduler"`
+	Retention  RetentionPolicy  `yaml:"retention"`
+	Similarity SimilarityConfig `yaml:"similarity"`
+	Scoring    ScoringConfig    `yaml:"scoring"`
+	HotSeat    HotSeatConfig    `yaml:"hotSeat"`
+	Ink        InkConfig        `yaml:"ink"`
+
+	Player        string `yaml:"player"`        // name under which rounds are recorded
+	DataDirectory string `yaml:"dataDirectory"` // where the history of rounds played is kept
+	TempDirectory string `yaml:"tempDirectory"`
+}
+
+type WindowConfig struct {
+	Title           string `yaml:"title"`
+	Width           int    `yaml:"width"`  // best to set to near/at native resolution
+	Height          int    `yaml:"height"` // best to set to near/at native resolution
+	TargetFramerate int    `yaml:"targetFramerate"`
+	VSyncEnabled    bool   `yaml:"vSyncEnabled"`
+}
+
+type BrushConfig struct {
+	Smoothing bool   `yaml:"smoothing"` // curve strokes through the mouse samples rather than joining them with straight lines
+	UndoInk   string `yaml:"undoInk"`   // what becomes of the ink of a stroke undone, one of: keep, refund
+}
+
+type TimerConfig struct {
+	CountdownSec int64 `yaml:"countdownSec"`
+	EasySec      int64 `yaml:"easySec"`
+	NormalSec    int64 `yaml:"normalSec"`
+	HardSec      int64 `yaml:"hardSec"`
+}
+
+type GuesserConfig struct {
+	Backend       string                `yaml:"backend"` // one of: gpt, recorded
+	IntervalSec   int64                 `yaml:"intervalSec"`
+	Ability       openai.ImageURLDetail `yaml:"ability"` // one of: low, high, auto
+	RecordedFile  string                `yaml:"recordedFile"`
+	RecordedDelay time.Duration         `yaml:"recordedDelay"`
+	SaveSnapshots bool                  `yaml:"saveSnapshots"` // keep each drawing sent to the guesser
+	MatchDistance int                   `yaml:"matchDistance"` // letters a word of a guess may be off by; 0 to disable
+	Alternates    int                   `yaml:"alternates"`    // other guesses asked for with each guess
+
+	// fraction of the canvas that must change before it is guessed at again;
+	// 0 means any change and a negative value means always
+	ChangeThreshold float64 `yaml:"changeThreshold"`
+}
+
+type ChallengeConfig struct {
+	Backend      string `yaml:"backend"` // one of: gpt, wordlist, wordbank
+	WordListFile string `yaml:"wordListFile"`
+	Seed         int64  `yaml:"seed"` // for the wordbank backend; 0 means use a random seed
+
+	PromptHistory int `yaml:"promptHistory"` // most recent objects listed in the prompt, for the gpt backend
+	Attempts      int `yaml:"attempts"`      // requests made for a valid object not used before, before accepting a repeat
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		Window: WindowConfig{
+			Title:           "Pictionary GPT",
+			Width:           1900,
+			Height:          1000,
+			TargetFramerate: 999, // effectively disable framerate-limiting
+			VSyncEnabled:    false,
+		},
+		Brush: BrushConfig{
+			UndoInk: "keep",
+		},
+		Timer: TimerConfig{
+			CountdownSec: 5,
+			EasySec:      60,
+			NormalSec:    45,
+			HardSec:      30,
+		},
+		Guesser: GuesserConfig{
+			Backend:       "gpt",
+			IntervalSec:   5,
+			Ability:       openai.ImageURLDetailLow,
+			RecordedFile:  "guesses.txt",
+			RecordedDelay: 500 * time.Millisecond,
+			Alternates:    2,
+		},
+		Challenge: ChallengeConfig{
+			Backend:       "gpt",
+			WordListFile:  "challenges.txt",
+			PromptHistory: 30,
+			Attempts:      3,
+		},
+		OpenAI: GptConfig{
+			APIType: gptAPITypeOpenAI,
+			Model:   openai.GPT4o,
+		},
+		Retry: RetryPolicy{
+			MaxAttempts: 4,
+			BaseDelay:   time.Second,
+			MaxDelay:    15 * time.Second,
+		},
+		Scheduler: SchedulerConfig{
+			MinInterval:      time.Second,
+			FailureThreshold: 3,
+			OpenDuration:     30 * time.Second,
+		},
+		Similarity: SimilarityConfig{
+			Backend:          "none",
+			Model:            string(openai.SmallEmbedding3),
+			PartialThreshold: .5,
+			FullThreshold:    .75,
+		},
+		Scoring: ScoringConfig{
+			PointsPerStar:    100,
+			TimeBonus:        150,
+			InkBonus:         50,
+			GuessPenalty:     10,
+			EasyMultiplier:   1,
+			NormalMultiplier: 1.5,
+			HardMultiplier:   2,
+		},
+		HotSeat: HotSeatConfig{
+			Rounds: 3,
+		},
+		Ink: InkConfig{
+			Easy:   "rgb",
+			Normal: "rgb",
+			Hard:   "cmyk",
+		},
+		Retention: RetentionPolicy{
+			KeepLast: 100,
+			MaxBytes: 100 << 20,
+		},
+		Player:        defaultPlayer(),
+		DataDirectory: defaultDataDirectory(),
+		TempDirectory: "/tmp/pictionary",
+	}
+}
+
+func defaultPlayer() string {
+	if user := os.Getenv("USER"); user != "" {
+		return user
+	}
+	return "Player"
+}
+
+func defaultDataDirectory() string {
+	if dir, err := os.UserConfigDir(); err == nil {
+		return path.Join(dir, "pictionary-gpt")
+	}
+	return ".pictionary"
+}
+
+/******************************************************************************
+ Config Functions
+******************************************************************************/
+
+// settings binds each setting to its flag and environment variable.  Unless
+// given, the environment variable is derived from the flag name, such that
+// 'timer-easy-sec' can be set with PICTIONARY_TIMER_EASY_SEC.
+func (c *Config) settings() []*configSetting {
+	return []*configSetting{
+		{flag: "window-title", value: &c.Window.Title, usage: "window title"},
+		{flag: "window-width", value: &c.Window.Width, usage: "window width, in pixels"},
+		{flag: "window-height", value: &c.Window.Height, usage: "window height, in pixels"},
+		{flag: "target-framerate", value: &c.Window.TargetFramerate, usage: "target framerate, in frames per second"},
+		{flag: "vsync", value: &c.Window.VSyncEnabled, usage: "enable vertical sync"},
+		{flag: "brush-smoothing", value: &c.Brush.Smoothing, usage: "curve strokes through the mouse samples (Catmull-Rom smoothing)"},
+		{flag: "brush-undo-ink", value: &c.Brush.UndoInk, usage: "what becomes of the ink of a stroke undone (keep, refund)"},
+		{flag: "timer-countdown-sec", value: &c.Timer.CountdownSec, usage: "countdown before each game starts, in seconds"},
+		{flag: "timer-easy-sec", value: &c.Timer.EasySec, usage: "time to draw on Easy, in seconds"},
+		{flag: "timer-normal-sec", value: &c.Timer.NormalSec, usage: "time to draw on Normal, in seconds"},
+		{flag: "timer-hard-sec", value: &c.Timer.HardSec, usage: "time to draw on Hard, in seconds"},
+		{flag: "guesser", value: &c.Guesser.Backend, usage: "guesser backend (gpt, recorded)"},
+		{flag: "guess-interval-sec", value: &c.Guesser.IntervalSec, usage: "time between guesses, in seconds"},
+		{flag: "guess-ability", value: &c.Guesser.Ability, usage: "image detail sent with each guess (low, high, auto)"},
+		{flag: "recorded-guesses-file", value: &c.Guesser.RecordedFile, usage: "file read by the recorded guesser"},
+		{flag: "recorded-guesses-delay", value: &c.Guesser.RecordedDelay, usage: "simulated latency of the recorded guesser"},
+		{flag: "guess-change-threshold", value: &c.Guesser.ChangeThreshold, usage: "fraction of the canvas that must change between guesses (negative to always guess)"},
+		{flag: "match-distance", value: &c.Guesser.MatchDistance, usage: "letters a word of a guess may be off by and still match (0 to disable)"},
+		{flag: "guess-alternates", value: &c.Guesser.Alternates, usage: "other guesses asked for with each guess, shown and given partial credit"},
+		{flag: "save-snapshots", value: &c.Guesser.SaveSnapshots, usage: "save each drawing sent to the guesser under the temp directory"},
+		{flag: "challenges", value: &c.Challenge.Backend, usage: "challenge backend (gpt, wordlist, wordbank)"},
+		{flag: "challenge-word-list", value: &c.Challenge.WordListFile, usage: "file read by the wordlist challenge backend"},
+		{flag: "challenge-seed", value: &c.Challenge.Seed, usage: "seed used by the wordbank challenge backend (0 for random)"},
+		{flag: "challenge-prompt-history", value: &c.Challenge.PromptHistory, usage: "most recent objects listed in the challenge prompt"},
+		{flag: "challenge-attempts", value: &c.Challenge.Attempts, usage: "requests made for a valid, unused object before accepting a repeat (or, for gpt, giving up on an invalid one)"},
+		{flag: "openai-api-key", env: "OPENAI_API_KEY", value: &c.OpenAI.APIKey, usage: "OpenAI API key"},
+		{flag: "openai-api-type", env: "OPENAI_API_TYPE", value: &c.OpenAI.APIType, usage: "API type (openai, azure)"},
+		{flag: "openai-api-version", env: "OPENAI_API_VERSION", value: &c.OpenAI.APIVersion, usage: "API version, for Azure OpenAI"},
+		{flag: "openai-base-url", env: "OPENAI_BASE_URL", value: &c.OpenAI.BaseURL, usage: "base URL of an OpenAI-compatible API"},
+		{flag: "openai-model", env: "OPENAI_MODEL", value: &c.OpenAI.Model, usage: "name of the model to use"},
+		{flag: "openai-org-id", env: "OPENAI_ORG_ID", value: &c.OpenAI.OrgID, usage: "OpenAI organization ID"},
+		{flag: "openai-project-id", env: "OPENAI_PROJECT_ID", value: &c.OpenAI.ProjectID, usage: "OpenAI project ID"},
+		{flag: "retry-max-attempts", value: &c.Retry.MaxAttempts, usage: "maximum attempts for each model call"},
+		{flag: "retry-base-delay", value: &c.Retry.BaseDelay, usage: "delay before the first retry of a model call"},
+		{flag: "retry-max-delay", value: &c.Retry.MaxDelay, usage: "maximum delay between retries of a model call"},
+		{flag: "scheduler-min-interval", value: &c.Scheduler.MinInterval, usage: "minimum time between model calls"},
+		{flag: "scheduler-failure-threshold", value: &c.Scheduler.FailureThreshold, usage: "consecutive failed model calls before pausing them"},
+		{flag: "scheduler-open-duration", value: &c.Scheduler.OpenDuration, usage: "how long model calls are paused after repeated failures"},
+		{flag: "retention-keep-last", value: &c.Retention.KeepLast, usage: "number of saved snapshots to keep (0 for no limit)"},
+		{flag: "retention-max-bytes", value: &c.Retention.MaxBytes, usage: "total size of saved snapshots to keep, in bytes (0 for no limit)"},
+		{flag: "retention-finals-only", value: &c.Retention.FinalsOnly, usage: "keep only the final snapshot of each round"},
+		{flag: "retention-delete-on-exit", value: &c.Retention.DeleteOnExit, usage: "delete saved snapshots on exit"},
+		{flag: "similarity", value: &c.Similarity.Backend, usage: "similarity backend for partial credit (none, gpt)"},
+		{flag: "similarity-model", value: &c.Similarity.Model, usage: "name of the embedding model to use"},
+		{flag: "similarity-partial-threshold", value: &c.Similarity.PartialThreshold, usage: "similarity at which a guess earns partial credit for the object"},
+		{flag: "similarity-full-threshold", value: &c.Similarity.FullThreshold, usage: "similarity at which a guess earns full credit for the object"},
+		{flag: "points-per-star", value: &c.Scoring.PointsPerStar, usage: "points earned for each star"},
+		{flag: "time-bonus", value: &c.Scoring.TimeBonus, usage: "points earned for winning with all the time remaining"},
+		{flag: "ink-bonus", value: &c.Scoring.InkBonus, usage: "points earned for winning without using any ink"},
+		{flag: "guess-penalty", value: &c.Scoring.GuessPenalty, usage: "points lost for each guess after the first"},
+		{flag: "player", value: &c.Player, usage: "name under which rounds are recorded"},
+		{flag: "hot-seat-players", value: &c.HotSeat.Players, usage: "comma-separated names of 2 to 8 players taking turns (hot-seat mode)"},
+		{flag: "hot-seat-rounds", value: &c.HotSeat.Rounds, usage: "rounds drawn by each player in hot-seat mode"},
+		{flag: "ink-model-easy", value: &c.Ink.Easy, usage: "ink model used on easy: rgb or cmyk"},
+		{flag: "ink-model-normal", value: &c.Ink.Normal, usage: "ink model used on normal: rgb or cmyk"},
+		{flag: "ink-model-hard", value: &c.Ink.Hard, usage: "ink model used on hard: rgb or cmyk"},
+		{flag: "data-directory", value: &c.DataDirectory, usage: "directory where the history of rounds played is kept"},
+		{flag: "temp-directory", value: &c.TempDirectory, usage: "directory used for temporary files"},
+	}
+}
+
+func (c *Config) validate() error {
+	var errs []error
+
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	check(c.Window.Width > 0 && c.Window.Height > 0, "window size must be positive")
+	check(c.Window.TargetFramerate > 0, "target framerate must be positive")
+	check(c.Brush.UndoInk == "keep" || c.Brush.UndoInk == "refund", "unknown undo ink rule: %s", c.Brush.UndoInk)
+	check(c.Timer.CountdownSec >= 0, "countdown must not be negative")
+	check(c.Timer.EasySec > 0 && c.Timer.NormalSec > 0 && c.Timer.HardSec > 0, "timer durations must be positive")
+	check(c.Guesser.IntervalSec > 0, "guess interval must be positive")
+	check(c.Guesser.ChangeThreshold < 1, "guess change threshold must be less than 1")
+	check(c.Guesser.MatchDistance >= 0, "match distance must not be negative")
+	check(c.Guesser.Alternates >= 0 && c.Guesser.Alternates <= 5, "guess alternates must be between 0 and 5")
+	check(c.Scoring.PointsPerStar >= 0 && c.Scoring.TimeBonus >= 0 && c.Scoring.InkBonus >= 0 &&
+		c.Scoring.GuessPenalty >= 0, "scoring points must not be negative")
+	check(c.Scoring.EasyMultiplier > 0 && c.Scoring.NormalMultiplier > 0 && c.Scoring.HardMultiplier > 0,
+		"scoring multipliers must be positive")
+	check(c.Challenge.PromptHistory >= 0, "challenge prompt history must not be negative")
+	check(c.Challenge.Attempts > 0, "challenge attempts must be positive")
+	check(c.Player != "", "player name is required")
+
+	if c.HotSeat.Enabled() {
+		check(len(c.HotSeat.Players) >= 2 && len(c.HotSeat.Players) <= 8, "hot-seat mode needs 2 to 8 players")
+		check(c.HotSeat.Rounds > 0, "hot-seat rounds must be positive")
+
+		seen := make(map[string]bool, len(c.HotSeat.Players))
+		for _, player := range c.HotSeat.Players {
+			check(player != "" && !seen[player], "hot-seat player names must be unique and not empty")
+			seen[player] = true
+		}
+	}
+	for _, name := range []string{c.Ink.Easy, c.Ink.Normal, c.Ink.Hard} {
+		_, err := newInkModel(name)
+		check(err == nil, "%v", err)
+	}
+	check(c.DataDirectory != "", "data directory is required")
+	check(c.TempDirectory != "", "temp directory is required")
+	check(c.Retry.MaxAttempts > 0, "retry attempts must be positive")
+	check(c.Retry.BaseDelay > 0 && c.Retry.MaxDelay >= c.Retry.BaseDelay, "retry delays must be positive, with the maximum no less than the base")
+	check(c.Scheduler.MinInterval >= 0, "scheduler interval must not be negative")
+	check(c.Scheduler.FailureThreshold > 0, "scheduler failure threshold must be positive")
+	check(c.Scheduler.OpenDuration > 0, "scheduler open duration must be positive")
+	check(c.Retention.KeepLast >= 0 && c.Retention.MaxBytes >= 0, "retention limits must not be negative")
+
+	switch c.Guesser.Ability {
+	case openai.ImageURLDetailLow, openai.ImageURLDetailHigh, openai.ImageURLDetailAuto:
+	default:
+		check(false, "unknown guess ability: %s", c.Guesser.Ability)
+	}
+
+	usesGpt := false
+
+	switch c.Guesser.Backend {
+	case "gpt":
+		usesGpt = true
+	case "recorded":
+		check(c.Guesser.RecordedFile != "", "recorded guesses file is required")
+	default:
+		check(false, "unknown guesser backend: %s", c.Guesser.Backend)
+	}
+
+	switch c.Challenge.Backend {
+	case "gpt":
+		usesGpt = true
+	case "wordlist":
+		check(c.Challenge.WordListFile != "", "challenge word list file is required")
+	case "wordbank":
+	default:
+		check(false, "unknown challenge backend: %s", c.Challenge.Backend)
+	}
+
+	switch c.Similarity.Backend {
+	case "none":
+	case "gpt":
+		usesGpt = true
+		check(c.Similarity.Model != "", "similarity model is required")
+		check(c.Similarity.PartialThreshold > 0 && c.Similarity.PartialThreshold <= c.Similarity.FullThreshold &&
+			c.Similarity.FullThreshold <= 1, "similarity thresholds must be between 0 and 1, with partial no more than full")
+	default:
+		check(false, "unknown similarity backend: %s", c.Similarity.Backend)
+	}
+
+	if usesGpt {
+		if err := c.OpenAI.validate(); err != nil {
+			errs = append(errs, fmt.Errorf("invalid OpenAI configuration: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// loadFile applies the settings found in the given YAML file.  Unknown keys
+// are reported as errors, to catch typos.
+func (c *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("error opening config file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	decoder := yaml.NewDecoder(file)
+	decoder.KnownFields(true)
+	if err = decoder.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error parsing config file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// loadConfig builds the Config from the defaults, the config file, the
+// environment and the given command-line arguments, in that order.  The config
+// file can be chosen with the -config flag or the PICTIONARY_CONFIG variable;
+// otherwise pictionary.yaml is used if it exists in the working directory.
+func loadConfig(args []string) (*Config, error) {
+	config := defaultConfig()
+	settings := config.settings()
+
+	flags := flag.NewFlagSet("pictionary-gpt", flag.ContinueOnError)
+	configFile := flags.String("config", "", "path to a YAML config file")
+
+	flagValues := make(map[string]string)
+	for _, s := range settings {
+		s := s
+		record := func(value string) error {
+			flagValues[s.flag] = value
+			return nil
+		}
+		if _, ok := s.value.(*bool); ok {
+			flags.BoolFunc(s.flag, s.usage, record)
+		} else {
+			flags.Func(s.flag, s.usage, record)
+		}
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+
+	if *configFile == "" {
+		*configFile = os.Getenv(configFileEnvVar)
+	}
+
+	if *configFile != "" {
+		if err := config.loadFile(*configFile); err != nil {
+			return nil, err
+		}
+	} else if _, err := os.Stat(defaultConfigFile); err == nil {
+		if err = config.loadFile(defaultConfigFile); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.envVar()); ok && value != "" {
+			if err := s.set(value); err != nil {
+				return nil, fmt.Errorf("invalid value for %s: %w", s.envVar(), err)
+			}
+		}
+	}
+
+	for _, s := range settings {
+		if value, ok := flagValues[s.flag]; ok {
+			if err := s.set(value); err != nil {
+				return nil, fmt.Errorf("invalid value for -%s: %w", s.flag, err)
+			}
+		}
+	}
+
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+/******************************************************************************
+ configSetting
+******************************************************************************/
+
+type configSetting struct {
+	flag  string
+	env   string
+	value any
+	usage string
+}
+
+func (s *configSetting) envVar() string {
+	if s.env != "" {
+		return s.env
+	}
+	return configEnvVarPrefix + strings.ToUpper(strings.ReplaceAll(s.flag, "-", "_"))
+}
+
+func (s *configSetting) set(text string) (err error) {
+	switch v := s.value.(type) {
+	case *string:
+		*v = text
+	case *int:
+		*v, err = strconv.Atoi(text)
+	case *int64:
+		*v, err = strconv.ParseInt(text, 10, 64)
+	case *float64:
+		*v, err = strconv.ParseFloat(text, 64)
+	case *bool:
+		*v, err = strconv.ParseBool(text)
+	case *time.Duration:
+		*v, err = time.ParseDuration(text)
+	case *[]string:
+		*v = nil
+		for _, item := range strings.Split(text, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*v = append(*v, item)
+			}
+		}
+	case *openai.ImageURLDetail:
+		*v = openai.ImageURLDetail(strings.ToLower(text))
+	default:
+		err = fmt.Errorf("unsupported setting type %T", s.value)
+	}
+	return
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+)
+
+/******************************************************************************
+ Test Helpers
+******************************************************************************/
+
+// clearConfigEnv blanks every variable loadConfig reads, which it then
+// ignores, so that the environment the tests run in cannot affect them.
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	t.Setenv(configFileEnvVar, "")
+	for _, s := range defaultConfig().settings() {
+		t.Setenv(s.envVar(), "")
+	}
+}
+
+func writeTestConfigFile(t *testing.T, text string) string {
+	t.Helper()
+	file := path.Join(t.TempDir(), "pictionary.yaml")
+	if err := os.WriteFile(file, []byte(text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+/******************************************************************************
+ Config Tests
+******************************************************************************/
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	clearConfigEnv(t)
+	file := writeTestConfigFile(t, `
+timer:
+  easySec: 90
+  normalSec: 50
+  hardSec: 40
+guesser:
+  backend: recorded
+challenge:
+  backend: wordbank
+retry:
+  baseDelay: 2s
+`)
+
+	t.Setenv("PICTIONARY_TIMER_NORMAL_SEC", "55")
+	t.Setenv("PICTIONARY_TIMER_HARD_SEC", "45")
+	t.Setenv("OPENAI_MODEL", "env-model")
+
+	config, err := loadConfig([]string{"-config", file, "-timer-hard-sec", "35", "-vsync"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		got, want any
+	}{
+		{"default", config.Timer.CountdownSec, int64(5)},
+		{"file", config.Timer.EasySec, int64(90)},
+		{"file over default", config.Retry.BaseDelay, 2 * time.Second},
+		{"env over file", config.Timer.NormalSec, int64(55)},
+		{"flag over env", config.Timer.HardSec, int64(35)},
+		{"env with its own name", config.OpenAI.Model, "env-model"},
+		{"bool flag", config.Window.VSyncEnabled, true},
+	}
+
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, test.got, test.want)
+		}
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	clearConfigEnv(t)
+	t.Setenv(configFileEnvVar, writeTestConfigFile(t, "player: Ada\nchallenge:\n  backend: wordbank\n"))
+
+	config, err := loadConfig(nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if config.Player != "Ada" || config.Challenge.Backend != "wordbank" {
+		t.Errorf("got player %q and challenge backend %q, want those of the file", config.Player, config.Challenge.Backend)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	clearConfigEnv(t)
+
+	tests := []struct {
+		name string
+		file string
+		args []string
+		env  map[string]string
+		want string
+	}{
+		{name: "unknown key", file: "timer:\n  easySecs: 90\n", want: "field easySecs not found"},
+		{name: "unknown section", file: "timers:\n  easySec: 90\n", want: "field timers not found"},
+		{name: "bad value in file", file: "timer:\n  easySec: soon\n", want: "error parsing config file"},
+		{name: "missing file", args: []string{"-config", "/nonexistent/pictionary.yaml"}, want: "error opening config file"},
+		{name: "unknown flag", args: []string{"-timer-easy"}, want: "flag provided but not defined"},
+		{name: "stray argument", args: []string{"easy"}, want: "unexpected arguments: easy"},
+		{name: "bad flag value", args: []string{"-timer-easy-sec", "soon"}, want: "invalid value for -timer-easy-sec"},
+		{name: "bad env value", env: map[string]string{"PICTIONARY_RETRY_BASE_DELAY": "5"}, want: "invalid value for PICTIONARY_RETRY_BASE_DELAY"},
+		{name: "invalid setting", args: []string{"-guesser", "psychic"}, want: "unknown guesser backend: psychic"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			args := test.args
+			if test.file != "" {
+				args = append([]string{"-config", writeTestConfigFile(t, test.file)}, args...)
+			}
+
+			if _, err := loadConfig(args); err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	if err := defaultConfig().validate(); err != nil {
+		t.Fatalf("default config is invalid: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   string
+	}{
+		{"window size", func(c *Config) { c.Window.Width = 0 }, "window size must be positive"},
+		{"undo ink", func(c *Config) { c.Brush.UndoInk = "lose" }, "unknown undo ink rule: lose"},
+		{"change threshold", func(c *Config) { c.Guesser.ChangeThreshold = 1 }, "change threshold must be less than 1"},
+		{"guess ability", func(c *Config) { c.Guesser.Ability = "psychic" }, "unknown guess ability: psychic"},
+		{"challenge backend", func(c *Config) { c.Challenge.Backend = "oracle" }, "unknown challenge backend: oracle"},
+		{"word list", func(c *Config) { c.Challenge.Backend, c.Challenge.WordListFile = "wordlist", "" }, "word list file is required"},
+		{"hot-seat players", func(c *Config) { c.HotSeat.Players = []string{"Ada"} }, "needs 2 to 8 players"},
+		{"hot-seat names", func(c *Config) { c.HotSeat.Players = []string{"Ada", "Ada"} }, "must be unique"},
+		{"ink model", func(c *Config) { c.Ink.Hard = "ryb" }, "ryb"},
+		{"retry delays", func(c *Config) { c.Retry.MaxDelay = c.Retry.BaseDelay / 2 }, "retry delays must be positive"},
+		{"similarity thresholds", func(c *Config) {
+			c.Similarity.Backend, c.Similarity.PartialThreshold = "gpt", .9
+		}, "similarity thresholds"},
+		{"openai", func(c *Config) { c.OpenAI.APIType = "azure" }, "invalid OpenAI configuration"},
+		{"base URL", func(c *Config) { c.OpenAI.BaseURL = "localhost:8080/v1" }, "must be an http or https URL"},
+	}
+
+	for _, test := range tests {
+		config := defaultConfig()
+		test.modify(config)
+		if err := config.validate(); err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want one containing %q", test.name, err, test.want)
+		}
+	}
+
+	// Every problem is reported, not just the first.
+	config := defaultConfig()
+	config.Window.Width = 0
+	config.Player = ""
+	if err := config.validate(); err == nil || !strings.Contains(err.Error(), "window size") ||
+		!strings.Contains(err.Error(), "player name") {
+		t.Errorf("got error %v, want both problems reported", err)
+	}
+}
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+var errIllegalTransition = errors.New("illegal round transition")
+
+/******************************************************************************
+ RoundState
+******************************************************************************/
+
+type RoundState int
+
+const (
+	Idle RoundState = iota
+	FetchingChallenge
+	Countdown
+	Drawing
+	Won
+	TimedOut
+	Aborted
+)
+
+// roundTransitions lists the states that each state may transition to, with
+// a finished round only able to make way for the next one.
+var roundTransitions = map[RoundState][]RoundState{
+	Idle:              {FetchingChallenge},
+	FetchingChallenge: {Countdown, Aborted},
+	Countdown:         {Drawing, Aborted},
+	Drawing:           {Won, TimedOut, Aborted},
+	Won:               {FetchingChallenge},
+	TimedOut:          {FetchingChallenge},
+	Aborted:           {FetchingChallenge},
+}
+
+func (s RoundState) String() string {
+	switch s {
+	case Idle:
+		return "Idle"
+	case FetchingChallenge:
+		return "FetchingChallenge"
+	case Countdown:
+		return "Countdown"
+	case Drawing:
+		return "Drawing"
+	case Won:
+		return "Won"
+	case TimedOut:
+		return "TimedOut"
+	case Aborted:
+		return "Aborted"
+	default:
+		return fmt.Sprintf("RoundState(%d)", int(s))
+	}
+}
+
+// Finished returns true if the round is over, for whatever reason.
+func (s RoundState) Finished() bool {
+	return s == Won || s == TimedOut || s == Aborted
+}
+
+func (s RoundState) canTransitionTo(to RoundState) bool {
+	for _, state := range roundTransitions[s] {
+		if state == to {
+			return true
+		}
+	}
+	return false
+}
+
+/******************************************************************************
+ Round
+******************************************************************************/
+
+// Round is a single challenge, from the moment it is requested until it is
+// won, the time runs out or it is aborted.  Player is only set in hot-seat
+// mode.  Stars are those awarded for the latest guess, Score is set once the
+// round is won or timed out and Err is set for aborted rounds.
+type Round struct {
+	Number      int
+	Player      string
+	Difficulty  Difficulty
+	Challenge   *Challenge
+	State       RoundState
+	Guesses     []string
+	Stars       int
+	Score       Score
+	TimeAllowed time.Duration
+	Started     time.Time // when drawing started
+	Ended       time.Time
+	Err         error
+}
+
+// FinalGuess returns the last guess made, if any.
+func (r *Round) FinalGuess() string {
+	if len(r.Guesses) == 0 {
+		return ""
+	}
+	return r.Guesses[len(r.Guesses)-1]
+}
+
+// TimeRemaining returns how much of the time allowed was left when the round
+// ended, or is left now if it has not.
+func (r *Round) TimeRemaining() time.Duration {
+	if r.Started.IsZero() {
+		return r.TimeAllowed
+	}
+
+	end := r.Ended
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	if remaining := r.TimeAllowed - end.Sub(r.Started); remaining > 0 {
+		return remaining
+	}
+	return 0
+}
+
+// RoundEvent is sent to subscribers on every transition, with a copy of the
+// round as of the transition.
+type RoundEvent struct {
+	From  RoundState
+	To    RoundState
+	Round Round
+}
+
+/******************************************************************************
+ GameSession
+******************************************************************************/
+
+// GameSession drives the rounds played in game mode through their states,
+// refusing any transition not listed in roundTransitions, such that double
+// clicks and guesses made after the time has run out have no effect.  The UI,
+// guesser and timer subscribe to transitions rather than changing each other.
+// No OpenGL is involved, so sessions can be exercised on their own.
+type GameSession struct {
+	generator ChallengeGenerator
+	history   []string
+
+	round  Round
+	rounds int
+
+	scoring  ScoringConfig
+	inkGauge func() float64
+	total    int
+	match    *HotSeatMatch
+
+	onTransitionHandlers []func(RoundEvent)
+
+	stateMutex sync.Mutex
+}
+
+// StartRound starts a new round at the given difficulty, fetching its
+// challenge in the background.  Once fetched, the round moves on to the
+// countdown, or is aborted if the challenge could not be fetched.
+func (s *GameSession) StartRound(ctx context.Context, difficulty Difficulty, timeAllowed time.Duration) error {
+	s.stateMutex.Lock()
+
+	if !s.round.State.canTransitionTo(FetchingChallenge) {
+		s.stateMutex.Unlock()
+		return fmt.Errorf("%w: %s to %s", errIllegalTransition, s.round.State, FetchingChallenge)
+	}
+
+	player := ""
+	if s.match != nil {
+		if s.match.Finished() {
+			s.match.Reset()
+		}
+		player = s.match.CurrentPlayer()
+	}
+
+	s.rounds++
+	from := s.round.State
+	s.round = Round{
+		Number:      s.rounds,
+		Player:      player,
+		Difficulty:  difficulty,
+		State:       FetchingChallenge,
+		TimeAllowed: timeAllowed,
+	}
+	event := s.newEvent(from)
+	history := append([]string(nil), s.history...)
+	number := s.rounds
+
+	s.stateMutex.Unlock()
+	s.dispatch(event)
+
+	go s.fetchChallenge(ctx, number, difficulty, history)
+	return nil
+}
+
+// fetchChallenge fetches the challenge of the given round, which by the time
+// it is fetched may have been aborted, or even followed by another round,
+// neither of which it is then allowed to affect.
+func (s *GameSession) fetchChallenge(ctx context.Context, number int, difficulty Difficulty, history []string) {
+	challenge, err := s.generator.NextChallenge(ctx, difficulty, history)
+	if err != nil {
+		_ = s.transitionRound(number, Aborted, func(round *Round) {
+			round.Ended = time.Now()
+			round.Err = err
+		})
+		return
+	}
+
+	_ = s.transitionRound(number, Countdown, func(round *Round) {
+		round.Challenge = challenge
+		s.history = append(s.history, challenge.Object)
+	})
+}
+
+// CountdownFinished is called when the countdown ends and drawing begins.
+func (s *GameSession) CountdownFinished() error {
+	return s.transition(Drawing, func(round *Round) {
+		round.Started = time.Now()
+	})
+}
+
+// Guess records a guess made at the drawing, returning false if the round is
+// not in a state to accept guesses, in which case the guess should be
+// ignored.
+func (s *GameSession) Guess(guess string) bool {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	if s.round.State != Drawing {
+		return false
+	}
+
+	s.round.Guesses = append(s.round.Guesses, guess)
+	return true
+}
+
+// Award records the stars awarded for the latest guess, returning false if
+// the round is not in a state to accept guesses.
+func (s *GameSession) Award(stars int) bool {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	if s.round.State != Drawing {
+		return false
+	}
+
+	s.round.Stars = stars
+	return true
+}
+
+// Win is called when the challenge has been guessed correctly.
+func (s *GameSession) Win() error {
+	inkUsed := s.readInkGauge()
+	return s.transition(Won, func(round *Round) {
+		round.Ended = time.Now()
+		s.score(round, inkUsed)
+	})
+}
+
+// TimeUp is called when the time allowed for drawing has run out.
+func (s *GameSession) TimeUp() error {
+	inkUsed := s.readInkGauge()
+	return s.transition(TimedOut, func(round *Round) {
+		round.Ended = time.Now()
+		s.score(round, inkUsed)
+	})
+}
+
+// Abort ends the current round without a result, for the given reason.
+func (s *GameSession) Abort(reason error) error {
+	return s.transition(Aborted, func(round *Round) {
+		round.Ended = time.Now()
+		round.Err = reason
+	})
+}
+
+// readInkGauge returns the fraction of ink used, as reported by the ink gauge.
+// It must be called without the state mutex held, as the gauge takes locks of
+// its own, such as the brush's.
+func (s *GameSession) readInkGauge() float64 {
+	s.stateMutex.Lock()
+	gauge := s.inkGauge
+	s.stateMutex.Unlock()
+
+	if gauge == nil {
+		return 0
+	}
+	return gauge()
+}
+
+// score must be called with the state mutex held.
+func (s *GameSession) score(round *Round, inkUsed float64) {
+	round.Score = scoreRound(s.scoring, round, inkUsed)
+	s.total += round.Score.Points
+
+	if s.match != nil {
+		s.match.Record(round.Player, round.Score.Points)
+	}
+}
+
+// transition moves the current round to the given state, applying the
+// update while the state mutex is held, then dispatches the event.
+func (s *GameSession) transition(to RoundState, update func(round *Round)) error {
+	return s.transitionRound(0, to, update)
+}
+
+// transitionRound is like transition, but only moves the round with the
+// given number, if that is still the current round; zero means any round.
+func (s *GameSession) transitionRound(number int, to RoundState, update func(round *Round)) error {
+	s.stateMutex.Lock()
+
+	from := s.round.State
+	if number != 0 && number != s.round.Number {
+		s.stateMutex.Unlock()
+		return fmt.Errorf("%w: round %d is over", errIllegalTransition, number)
+	}
+	if !from.canTransitionTo(to) {
+		s.stateMutex.Unlock()
+		return fmt.Errorf("%w: %s to %s", errIllegalTransition, from, to)
+	}
+
+	s.round.State = to
+	if update != nil {
+		update(&s.round)
+	}
+	event := s.newEvent(from)
+
+	s.stateMutex.Unlock()
+	s.dispatch(event)
+	return nil
+}
+
+// newEvent must be called with the state mutex held.
+func (s *GameSession) newEvent(from RoundState) RoundEvent {
+	round := s.round
+	round.Guesses = append([]string(nil), s.round.Guesses...)
+	return RoundEvent{
+		From:  from,
+		To:    s.round.State,
+		Round: round,
+	}
+}
+
+func (s *GameSession) dispatch(event RoundEvent) {
+	s.stateMutex.Lock()
+	handlers := s.onTransitionHandlers
+	s.stateMutex.Unlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
+
+func (s *GameSession) State() RoundState {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	return s.round.State
+}
+
+// Round returns a copy of the current (or last) round.
+func (s *GameSession) Round() Round {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	round := s.round
+	round.Guesses = append([]string(nil), s.round.Guesses...)
+	return round
+}
+
+// Total returns the points scored across every round of the session.
+func (s *GameSession) Total() int {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	return s.total
+}
+
+// SetInkGauge sets the function reporting the fraction of ink used in the
+// current round, from 0 to 1, for scoring.  If nil, no ink is deemed used.
+func (s *GameSession) SetInkGauge(gauge func() float64) *GameSession {
+	s.stateMutex.Lock()
+	s.inkGauge = gauge
+	s.stateMutex.Unlock()
+	return s
+}
+
+// SetObjectHistory replaces the objects of previous challenges, which the
+// challenges of later rounds will avoid.
+func (s *GameSession) SetObjectHistory(objects []string) *GameSession {
+	s.stateMutex.Lock()
+	s.history = append([]string(nil), objects...)
+	s.stateMutex.Unlock()
+	return s
+}
+
+// HotSeatMatch returns the match being played, which is nil unless in
+// hot-seat mode.
+func (s *GameSession) HotSeatMatch() *HotSeatMatch {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	return s.match
+}
+
+// SetHotSeatMatch puts the session in hot-seat mode, with each round drawn by
+// the player whose turn it is in the given match.  Finished rounds are scored
+// in the match, which starts over when the next round is started after the
+// match is over.
+func (s *GameSession) SetHotSeatMatch(match *HotSeatMatch) *GameSession {
+	s.stateMutex.Lock()
+	s.match = match
+	s.stateMutex.Unlock()
+	return s
+}
+
+// OnTransition adds a handler that will be called after every transition.
+// Handlers are called on the goroutine that caused the transition, which may
+// or may not be the render thread.
+func (s *GameSession) OnTransition(handler func(event RoundEvent)) {
+	s.stateMutex.Lock()
+	s.onTransitionHandlers = append(s.onTransitionHandlers, handler)
+	s.stateMutex.Unlock()
+}
+
+/******************************************************************************
+ New GameSession Function
+******************************************************************************/
+
+func NewGameSession(generator ChallengeGenerator, scoring ScoringConfig) *GameSession {
+	return &GameSession{
+		generator: generator,
+		scoring:   scoring,
+	}
+}
@@ -0,0 +1,301 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+/******************************************************************************
+ Test Helpers
+******************************************************************************/
+
+// gatedChallengeGenerator holds every request for a challenge until the test
+// answers it, sending each request's reply channel to requests.
+type gatedChallengeGenerator struct {
+	requests chan chan *Challenge
+}
+
+func (g *gatedChallengeGenerator) NextChallenge(ctx context.Context, difficulty Difficulty, _ []string) (*Challenge, error) {
+	reply := make(chan *Challenge)
+	g.requests <- reply
+	select {
+	case challenge := <-reply:
+		challenge.Difficulty = difficulty
+		return challenge, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func newGatedChallengeGenerator() *gatedChallengeGenerator {
+	return &gatedChallengeGenerator{requests: make(chan chan *Challenge)}
+}
+
+func newTestGameSession(generator ChallengeGenerator) (*GameSession, chan RoundEvent) {
+	session := NewGameSession(generator, ScoringConfig{
+		PointsPerStar:    10,
+		EasyMultiplier:   1,
+		NormalMultiplier: 1,
+		HardMultiplier:   1,
+	})
+
+	events := make(chan RoundEvent, 16)
+	session.OnTransition(func(event RoundEvent) {
+		events <- event
+	})
+	return session, events
+}
+
+func waitForRoundState(t *testing.T, events chan RoundEvent, state RoundState) RoundEvent {
+	t.Helper()
+	for {
+		select {
+		case event := <-events:
+			if event.To == state {
+				return event
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %s", state)
+		}
+	}
+}
+
+// startDrawing starts a round and takes it through the countdown.
+func startDrawing(t *testing.T, session *GameSession, events chan RoundEvent) {
+	t.Helper()
+	if err := session.StartRound(context.Background(), Easy, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitForRoundState(t, events, Countdown)
+	if err := session.CountdownFinished(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func expectIllegalTransition(t *testing.T, name string, err error) {
+	t.Helper()
+	if !errors.Is(err, errIllegalTransition) {
+		t.Errorf("%s: got error %v, want %v", name, err, errIllegalTransition)
+	}
+}
+
+/******************************************************************************
+ GameSession Tests
+******************************************************************************/
+
+func TestGameSessionRejectsDoubleStartRound(t *testing.T) {
+	generator := newGatedChallengeGenerator()
+	session, events := newTestGameSession(generator)
+
+	if err := session.StartRound(context.Background(), Easy, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reply := <-generator.requests
+
+	expectIllegalTransition(t, "second StartRound", session.StartRound(context.Background(), Hard, time.Minute))
+	if round := session.Round(); round.Number != 1 || round.Difficulty != Easy {
+		t.Errorf("got round %d at %s, want round 1 at %s", round.Number, round.Difficulty, Easy)
+	}
+
+	reply <- &Challenge{Color: "Red", Object: "ball"}
+	if event := waitForRoundState(t, events, Countdown); event.Round.Challenge.Object != "ball" {
+		t.Errorf("got challenge %s, want ball", event.Round.Challenge)
+	}
+}
+
+func TestGameSessionIgnoresGuessesAfterTimeUp(t *testing.T) {
+	session, events := newTestGameSession(&scriptedChallengeGenerator{objects: []string{"ball"}})
+	startDrawing(t, session, events)
+
+	if !session.Guess("red ball") || !session.Award(2) {
+		t.Fatal("guess while drawing was not accepted")
+	}
+	if err := session.TimeUp(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if session.Guess("late guess") {
+		t.Error("guess after time up was accepted")
+	}
+	if session.Award(3) {
+		t.Error("award after time up was accepted")
+	}
+	expectIllegalTransition(t, "Win after TimeUp", session.Win())
+
+	round := session.Round()
+	if round.State != TimedOut || len(round.Guesses) != 1 || round.Stars != 2 {
+		t.Errorf("got %s with guesses %v and %d stars, want TimedOut with 1 guess and 2 stars",
+			round.State, round.Guesses, round.Stars)
+	}
+	if round.Score.Points != 20 || session.Total() != 20 {
+		t.Errorf("got %d points (%d total), want 20", round.Score.Points, session.Total())
+	}
+}
+
+func TestGameSessionAbortDuringFetchingChallenge(t *testing.T) {
+	generator := newGatedChallengeGenerator()
+	session, events := newTestGameSession(generator)
+
+	if err := session.StartRound(context.Background(), Easy, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stale := <-generator.requests
+
+	reason := errors.New("player gave up")
+	if err := session.Abort(reason); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if round := session.Round(); round.State != Aborted || round.Err != reason {
+		t.Errorf("got %s with error %v, want Aborted with %v", round.State, round.Err, reason)
+	}
+
+	// The aborted round's challenge, arriving late, must not be given to
+	// the next round.
+	if err := session.StartRound(context.Background(), Easy, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	fresh := <-generator.requests
+	for len(events) > 0 {
+		<-events
+	}
+
+	stale <- &Challenge{Color: "Red", Object: "stale"}
+	select {
+	case event := <-events:
+		t.Fatalf("late challenge moved round %d to %s", event.Round.Number, event.To)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	fresh <- &Challenge{Color: "Blue", Object: "fresh"}
+
+	event := waitForRoundState(t, events, Countdown)
+	if event.Round.Number != 2 || event.Round.Challenge.Object != "fresh" {
+		t.Errorf("got round %d with challenge %s, want round 2 with fresh", event.Round.Number, event.Round.Challenge)
+	}
+}
+
+func TestGameSessionFetchErrorDoesNotAbortNextRound(t *testing.T) {
+	generator := newGatedChallengeGenerator()
+	session, events := newTestGameSession(generator)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if err := session.StartRound(ctx, Easy, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	<-generator.requests
+	if err := session.Abort(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := session.StartRound(context.Background(), Easy, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	reply := <-generator.requests
+	for len(events) > 0 {
+		<-events
+	}
+
+	cancel()
+	select {
+	case event := <-events:
+		t.Fatalf("late error moved round %d to %s", event.Round.Number, event.To)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	reply <- &Challenge{Color: "Blue", Object: "kite"}
+	if event := waitForRoundState(t, events, Countdown); event.Round.Number != 2 {
+		t.Errorf("got round %d, want round 2", event.Round.Number)
+	}
+}
+
+func TestGameSessionAbortDuringCountdown(t *testing.T) {
+	session, events := newTestGameSession(&scriptedChallengeGenerator{objects: []string{"ball"}})
+
+	if err := session.StartRound(context.Background(), Easy, time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitForRoundState(t, events, Countdown)
+
+	if err := session.Abort(nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectIllegalTransition(t, "CountdownFinished after Abort", session.CountdownFinished())
+	if session.Guess("red ball") {
+		t.Error("guess after abort was accepted")
+	}
+
+	round := session.Round()
+	if round.State != Aborted || round.Ended.IsZero() || !round.Started.IsZero() {
+		t.Errorf("got %s, started %v and ended %v, want Aborted before drawing", round.State, round.Started, round.Ended)
+	}
+}
+
+func TestGameSessionIllegalTransitions(t *testing.T) {
+	session, events := newTestGameSession(&scriptedChallengeGenerator{objects: []string{"ball"}})
+
+	expectIllegalTransition(t, "CountdownFinished when idle", session.CountdownFinished())
+	expectIllegalTransition(t, "Win when idle", session.Win())
+	expectIllegalTransition(t, "TimeUp when idle", session.TimeUp())
+	expectIllegalTransition(t, "Abort when idle", session.Abort(nil))
+
+	startDrawing(t, session, events)
+	expectIllegalTransition(t, "StartRound while drawing", session.StartRound(context.Background(), Easy, time.Minute))
+	expectIllegalTransition(t, "CountdownFinished while drawing", session.CountdownFinished())
+
+	if err := session.Win(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expectIllegalTransition(t, "Win when won", session.Win())
+	expectIllegalTransition(t, "TimeUp when won", session.TimeUp())
+	expectIllegalTransition(t, "Abort when won", session.Abort(nil))
+
+	if state := session.State(); state != Won {
+		t.Errorf("got %s, want Won", state)
+	}
+
+	// A finished round makes way for the next.
+	if err := session.StartRound(context.Background(), Easy, time.Minute); err != nil {
+		t.Errorf("StartRound when won: unexpected error: %v", err)
+	}
+}
+
+func TestGameSessionReadsInkGaugeWithoutLock(t *testing.T) {
+	session := NewGameSession(&scriptedChallengeGenerator{objects: []string{"ball"}}, ScoringConfig{
+		InkBonus:         100,
+		EasyMultiplier:   1,
+		NormalMultiplier: 1,
+		HardMultiplier:   1,
+	})
+	events := make(chan RoundEvent, 16)
+	session.OnTransition(func(event RoundEvent) {
+		events <- event
+	})
+
+	// A gauge taking the session's own lock, which deadlocks if the gauge is
+	// read with it held, as taking the brush's lock could.
+	session.SetInkGauge(func() float64 {
+		_ = session.State()
+		return .25
+	})
+	startDrawing(t, session, events)
+
+	done := make(chan error)
+	go func() {
+		done <- session.Win()
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Win deadlocked reading the ink gauge")
+	}
+
+	if bonus := session.Round().Score.InkBonus; bonus != 75 {
+		t.Errorf("got an ink bonus of %d, want 75", bonus)
+	}
+}
@@ -6,13 +6,14 @@ toolchain go1.21.4
 
 require (
 	github.com/go-gl/gl v0.0.0-20231021071112-07e5d0ea2e71
+	github.com/go-gl/glfw/v3.3/glfw v0.0.0-20240307211618-a69d953ea142
 	github.com/go-gl/mathgl v1.1.0
 	github.com/sashabaranov/go-openai v1.24.1
 	github.com/tonybillings/gfx v0.0.0-20240524163728-8da8f2b2c70c
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
-	github.com/go-gl/glfw/v3.3/glfw v0.0.0-20240307211618-a69d953ea142 // indirect
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
 	golang.org/x/image v0.16.0 // indirect
 	gonum.org/v1/gonum v0.15.0 // indirect
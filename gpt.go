@@ -2,83 +2,439 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/sashabaranov/go-openai"
+	"net/http"
+	"net/url"
 	"os"
 	"strings"
 	"time"
+	"unicode"
+)
+
+var errInvalidChallenge = errors.New("invalid challenge")
+
+const (
+	gptAPITypeOpenAI = "openai"
+	gptAPITypeAzure  = "azure"
 )
 
 const (
 	gptGuessPrompt = `Describe this drawing using just 1 to 4 words, preferably 
 using a color if it is primarily comprised of one color (e.g., 'Orange cat' or 
 'Red barn').  If the image is just a solid color or just has a few 'sketch marks' 
-or dots, etc, then respond with an empty string, no special characters, until you 
-can make out an object or scene and then you can describe it with 1 to 4 words as 
-instructed previously. The colors you are allowed to use when describing the object 
-must be one of [Black|White|Red|Green|Blue|Yellow|Orange|Purple|Teal|Pink|Brown].`
-
-	gptStartGamePrompt = `Here are the game rules.  If the Difficulty is set to 
-Easy, you shall choose a Color that is one of [Black|White|Red|Green|Blue]; if 
-the Difficulty is set to Normal, you shall choose a Color that is one of 
-[Black|White|Red|Green|Blue|Yellow|Orange|Purple|Teal|Pink|Brown]; if the 
-Difficulty is set to Hard, you shall choose a Color that is one of 
-[Orange|Purple|Teal|Pink|Brown]. After you have chosen a Color, you shall then 
+or dots, etc, then respond with an empty guess until you can make out an object 
+or scene and then you can describe it with 1 to 4 words as instructed previously. 
+The colors you are allowed to use when describing the object must be one of 
+[Black|White|Red|Green|Blue|Yellow|Orange|Purple|Teal|Pink|Brown]. Respond with 
+a JSON object only, with the keys "guess" (your description), "color" (the main 
+color of the drawing, or an empty string), "confidence" (from 0 to 1, how sure 
+you are of your guess) and "alternates" (up to %d other descriptions, of 1 to 4 
+words each, that you were considering, best first), for example {"guess": "Orange 
+cat", "color": "Orange", "confidence": 0.6, "alternates": ["Orange fox"]}.`
+
+	gptStartGamePrompt = `Here are the game rules.  %s After you have chosen a Color, you shall then 
 choose an Object that can be described in 1 or 2 words; the Difficulty should 
 influence the Object chosen, such that "easy objects" are those that would take  
 fewer brush strokes to draw/paint, while "hard objects" would take more strokes, 
-may require multiple colors/sub-shapes to make the object distinguished, etc. An 
-example Easy response would be 'Red ball' while an example Hard response would be 
-'Pink football field'. Notice that the only adjective used to describe the object 
-is the Color (i.e., you should avoid object descriptions like: 'tall hat' or 
-'Black tall hat', etc). Objects can come from any context, like nature, sports, 
-games, fiction, office/home spaces, etc. Do not respond with any preceding comments 
-when we play the game and do not repeat previous responses (do not choose the same 
-object twice, even if using a different color; review the current context/history of 
-this conversation to learn which objects should not be used again).  OK, let's play 
-the game now. The Difficulty has been set to `
-)
+may require multiple colors/sub-shapes to make the object distinguished, etc. 
+Respond with a JSON object only, with the keys "color", "object" and "difficulty". 
+An example Easy response would be {"color": "Red", "object": "ball", "difficulty": "Easy"} 
+while an example Hard response would be {"color": "Pink", "object": "football field", 
+"difficulty": "Hard"}. Notice that the object does not include the Color and that 
+no other adjective is used to describe the object (i.e., you should avoid object 
+descriptions like: 'tall hat', etc). Objects can come from any context, like nature, 
+sports, games, fiction, office/home spaces, etc. Do not repeat previous responses 
+(do not choose the same object twice, even if using a different color; review the 
+current context/history of this conversation to learn which objects should not be 
+used again).  OK, let's play the game now. The Difficulty has been set to %s`
 
-func guessRoutine(ctx context.Context, imageDirectory string, exportImageFunc func(), makeGuessFunc func(string)) {
-	client := newGptClient()
+	gptInvalidChallengePrompt = `That response breaks the rules of the game: %s. Respond 
+again with a JSON object only, following the rules.`
+)
 
+// guessRoutine periodically has the guesser guess at a snapshot of the canvas,
+// skipping those the detector finds unchanged since the last guess and saving
+// the rest to the given store unless it is nil.  Failed
+// guesses are retried by the RequestScheduler behind the guesser, which also
+// keeps the player informed, so here they are simply skipped.
+func guessRoutine(ctx context.Context, captureFunc func(context.Context) (*Snapshot, error), guesser Guesser,
+	makeGuessFunc func(*Guess), detector *ChangeDetector, snapshots *SnapshotStore) {
 	for {
 		select {
 		case <-ctx.Done():
 			return
+		case <-time.After(time.Duration(cfg.Guesser.IntervalSec) * time.Second):
+		}
+
+		snapshot, err := captureFunc(ctx)
+		if err != nil {
+			return // the context was cancelled
+		}
+
+		if !detector.Changed(snapshot) {
+			continue
+		}
+
+		if snapshots != nil {
+			if err = snapshots.Save(snapshot, false); err != nil {
+				_, _ = fmt.Fprintln(os.Stderr, err)
+			}
+		}
+
+		guess, err := guesser.Guess(ctx, snapshot.PNG)
+
+		switch {
+		case err == nil:
+			makeGuessFunc(guess)
+		case ctx.Err() != nil:
+			return
+		case isConfigurationError(err):
+			reportFatalError(fmt.Errorf("API error: %w", err))
+			return
 		default:
+			detector.Reset()
+		}
+	}
+}
+
+/******************************************************************************
+ GptGuesser
+******************************************************************************/
+
+// GptGuesser is the Guesser backed by OpenAI's vision-capable chat models,
+// which are asked for up to the given number of alternates with each guess.
+type GptGuesser struct {
+	client     *openai.Client
+	model      string
+	detail     openai.ImageURLDetail
+	alternates int
+}
+
+func (g *GptGuesser) Guess(ctx context.Context, pngImage []byte) (*Guess, error) {
+	start := time.Now()
+
+	prompt := fmt.Sprintf(gptGuessPrompt, g.alternates)
+	req := newImageCompletionRequest(g.model, g.detail, prompt, base64.StdEncoding.EncodeToString(pngImage))
+	resp, err := g.client.CreateChatCompletion(ctx, *req)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Choices) == 0 {
+		return nil, errors.New("response contained no choices")
+	}
+
+	guess := parseGuess(resp.Choices[0].Message.Content, g.alternates)
+	guess.Backend = "gpt"
+	guess.Model = resp.Model
+	guess.Latency = time.Since(start)
+	guess.Tokens = resp.Usage.TotalTokens
+	return guess, nil
+}
+
+func NewGptGuesser(client *openai.Client, model string, detail openai.ImageURLDetail, alternates int) *GptGuesser {
+	return &GptGuesser{
+		client:     client,
+		model:      model,
+		detail:     detail,
+		alternates: alternates,
+	}
+}
+
+/******************************************************************************
+ GptChallengeGenerator
+******************************************************************************/
+
+// GptChallengeGenerator is the ChallengeGenerator that has the chat model
+// choose the challenge, following the rules given in gptStartGamePrompt.  The
+// challenge is requested as JSON and checked against those rules, as the
+// model does not always follow them, and against the history, as only part of
+// it is listed in the prompt.  Responses that break the rules or repeat an
+// object are sent back to the model, with the reason, for another try.  Each
+// request is made through the scheduler, if given, on its own.
+type GptChallengeGenerator struct {
+	client    *openai.Client
+	model     string
+	bank      *WordBank
+	scheduler *RequestScheduler
+
+	historyLimit int
+	attempts     int
+}
+
+// gptChallenge is the JSON object the model is asked to respond with.
+type gptChallenge struct {
+	Color      string `json:"color"`
+	Object     string `json:"object"`
+	Difficulty string `json:"difficulty"`
+}
+
+// NextChallenge makes up to the generator's attempts at requests in all.
+// Should the last one still repeat an object, it is accepted, as a repeat is
+// better than no game at all, but if it breaks the rules errInvalidChallenge
+// is returned.
+func (g *GptChallengeGenerator) NextChallenge(ctx context.Context, difficulty Difficulty, history []string) (*Challenge, error) {
+	prompt := fmt.Sprintf(gptStartGamePrompt, g.bank.colorRules(), difficulty)
+	req := newChallengeCompletionRequest(g.model, prompt, summarizeHistory(history, g.historyLimit))
+
+	used := make(map[string]bool, len(history))
+	for _, object := range history {
+		used[objectKey(object)] = true
+	}
+
+	var err error
+	for attempt := 1; attempt <= g.attempts; attempt++ {
+		content, e := g.complete(ctx, req)
+		if e != nil {
+			return nil, e
+		}
+
+		challenge, e := g.parseChallenge(content, difficulty)
+		if e == nil && (!used[objectKey(challenge.Object)] || attempt == g.attempts) {
+			return challenge, nil
+		}
+		if e == nil {
+			e = fmt.Errorf("the object %q has been used already", challenge.Object)
 		}
 
-		if img := getLatestDrawing(imageDirectory); img != "" {
-			base64Image := getImageB64(img)
+		err = e
+		req.Messages = append(req.Messages,
+			openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleAssistant,
+				Content: content,
+			},
+			openai.ChatCompletionMessage{
+				Role:    openai.ChatMessageRoleUser,
+				Content: fmt.Sprintf(gptInvalidChallengePrompt, e),
+			})
+	}
+
+	return nil, fmt.Errorf("%w: %v", errInvalidChallenge, err)
+}
+
+// complete makes a single request, returning the content of the response.
+func (g *GptChallengeGenerator) complete(ctx context.Context, req *openai.ChatCompletionRequest) (content string, err error) {
+	request := func(ctx context.Context) error {
+		resp, e := g.client.CreateChatCompletion(ctx, *req)
+		if e != nil {
+			return e
+		}
+
+		if len(resp.Choices) == 0 {
+			return errors.New("response contained no choices")
+		}
+
+		content = resp.Choices[0].Message.Content
+		return nil
+	}
+
+	if g.scheduler == nil {
+		err = request(ctx)
+	} else {
+		err = g.scheduler.Do(ctx, request)
+	}
+	return
+}
+
+// parseChallenge returns an error giving the reason the response breaks the
+// rules, if it does, in a form that can be sent back to the model.
+func (g *GptChallengeGenerator) parseChallenge(content string, difficulty Difficulty) (*Challenge, error) {
+	var response gptChallenge
+	if err := json.Unmarshal([]byte(content), &response); err != nil {
+		return nil, errors.New("the response is not a JSON object with the keys given")
+	}
+
+	if d, err := parseDifficulty(response.Difficulty); err != nil || d != difficulty {
+		return nil, fmt.Errorf("the difficulty must be %s, not %q", difficulty, response.Difficulty)
+	}
+
+	color, ok := g.bank.AllowedColor(difficulty, strings.TrimSpace(response.Color))
+	if !ok {
+		return nil, fmt.Errorf("the color must be one of [%s], not %q",
+			strings.Join(g.bank.Colors(difficulty), "|"), response.Color)
+	}
+
+	objectWords := strings.Fields(response.Object)
+	if len(objectWords) < 1 || len(objectWords) > 2 {
+		return nil, fmt.Errorf("the object must be 1 or 2 words, not %q", response.Object)
+	}
 
-			resp, err := client.CreateChatCompletion(
-				context.Background(),
-				*newImageCompletionRequest(base64Image),
-			)
+	if strings.EqualFold(objectWords[0], color) {
+		return nil, fmt.Errorf("the object must not include the color, as %q does", response.Object)
+	}
 
-			if err == nil {
-				guess := formatGuess(resp.Choices[0].Message.Content)
-				makeGuessFunc(guess)
-			} else {
-				panic(fmt.Errorf("API error: %w\n", err))
+	for _, word := range objectWords {
+		for _, r := range word {
+			if !unicode.IsLetter(r) && r != '-' && r != '\'' {
+				return nil, fmt.Errorf("the object must be made of words only, not %q", response.Object)
 			}
 		}
+	}
+
+	return &Challenge{
+		Color:      color,
+		Object:     strings.Join(objectWords, " "),
+		Difficulty: difficulty,
+	}, nil
+}
+
+// NewGptChallengeGenerator returns a generator that lists at most
+// historyLimit of the most recent objects in its prompt, to keep the prompt
+// from growing with every game played, and makes at most attempts requests
+// for each challenge.  The scheduler may be nil.
+func NewGptChallengeGenerator(client *openai.Client, model string, bank *WordBank, scheduler *RequestScheduler,
+	historyLimit, attempts int) *GptChallengeGenerator {
+	return &GptChallengeGenerator{
+		client:       client,
+		model:        model,
+		bank:         bank,
+		scheduler:    scheduler,
+		historyLimit: historyLimit,
+		attempts:     attempts,
+	}
+}
+
+/******************************************************************************
+ GptEmbedder
+******************************************************************************/
+
+// GptEmbedder is the Embedder backed by OpenAI's embeddings API, or that of
+// any compatible server, such as Ollama running a local embedding model.
+type GptEmbedder struct {
+	client *openai.Client
+	model  string
+}
+
+func (e *GptEmbedder) Embed(ctx context.Context, texts ...string) ([][]float32, error) {
+	resp, err := e.client.CreateEmbeddings(ctx, openai.EmbeddingRequest{
+		Input: texts,
+		Model: openai.EmbeddingModel(e.model),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(resp.Data) != len(texts) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(texts), len(resp.Data))
+	}
+
+	vectors := make([][]float32, len(texts))
+	for _, embedding := range resp.Data {
+		if embedding.Index < 0 || embedding.Index >= len(texts) {
+			return nil, fmt.Errorf("embedding index out of range: %d", embedding.Index)
+		}
+		vectors[embedding.Index] = embedding.Embedding
+	}
+
+	return vectors, nil
+}
+
+func NewGptEmbedder(client *openai.Client, model string) *GptEmbedder {
+	return &GptEmbedder{
+		client: client,
+		model:  model,
+	}
+}
+
+/******************************************************************************
+ GptConfig
+******************************************************************************/
+
+// GptConfig determines which OpenAI-compatible server is used, and how.  Any
+// server implementing the chat completions API can be used, such as Azure
+// OpenAI, Ollama, llama.cpp or a stub server used for testing.
+type GptConfig struct {
+	APIKey     string `yaml:"apiKey"`
+	APIType    string `yaml:"apiType"`    // openai or azure
+	APIVersion string `yaml:"apiVersion"` // required when APIType is azure
+	BaseURL    string `yaml:"baseURL"`    // leave empty to use OpenAI's servers
+	Model      string `yaml:"model"`
+	OrgID      string `yaml:"orgID"`
+	ProjectID  string `yaml:"projectID"`
+}
+
+func (c *GptConfig) validate() error {
+	switch strings.ToLower(c.APIType) {
+	case gptAPITypeOpenAI:
+	case gptAPITypeAzure:
+		if c.BaseURL == "" {
+			return errors.New("a base URL is required when using Azure OpenAI")
+		}
+	default:
+		return fmt.Errorf("unknown API type: %s", c.APIType)
+	}
+
+	if c.BaseURL != "" {
+		if u, err := url.Parse(c.BaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("the base URL must be an http or https URL, not %q", c.BaseURL)
+		}
+	}
+
+	if c.Model == "" {
+		return errors.New("a model name is required")
+	}
+
+	return nil
+}
+
+/******************************************************************************
+ GPT Functions
+******************************************************************************/
+
+// newGptClient returns a client that sends its requests through the given
+// transport, so that the RequestScheduler can see the rate-limiting headers.
+func newGptClient(config *GptConfig, transport http.RoundTripper) (*openai.Client, error) {
+	if err := config.validate(); err != nil {
+		return nil, fmt.Errorf("invalid OpenAI configuration: %w", err)
+	}
+
+	var clientConfig openai.ClientConfig
+	if strings.ToLower(config.APIType) == gptAPITypeAzure {
+		clientConfig = openai.DefaultAzureConfig(config.APIKey, config.BaseURL)
+		if config.APIVersion != "" {
+			clientConfig.APIVersion = config.APIVersion
+		}
+	} else {
+		clientConfig = openai.DefaultConfig(config.APIKey)
+		if config.BaseURL != "" {
+			clientConfig.BaseURL = config.BaseURL
+		}
+	}
 
-		time.Sleep(gptGuessIntervalSec * time.Second)
-		exportImageFunc()
+	clientConfig.OrgID = config.OrgID
+
+	if config.ProjectID != "" {
+		transport = &headerTransport{
+			base:    transport,
+			headers: http.Header{"Openai-Project": {config.ProjectID}},
+		}
 	}
+
+	clientConfig.HTTPClient = &http.Client{Transport: transport}
+
+	return openai.NewClientWithConfig(clientConfig), nil
 }
 
-func newGptClient() *openai.Client {
-	return openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+// summarizeHistory lists the most recent objects of the history, up to the
+// given limit, noting how many older objects were left out.
+func summarizeHistory(history []string, limit int) string {
+	if len(history) <= limit {
+		return fmt.Sprintf("[%s]", strings.Join(history, "|"))
+	}
+
+	recent := history[len(history)-limit:]
+	return fmt.Sprintf("[%s] (plus %d older objects not listed)", strings.Join(recent, "|"), len(history)-limit)
 }
 
-func newTextCompletionRequest(text, previousObjects string) *openai.ChatCompletionRequest {
+func newChallengeCompletionRequest(model, text, previousObjects string) *openai.ChatCompletionRequest {
 	return &openai.ChatCompletionRequest{
-		Model: openai.GPT4o,
+		Model: model,
 		ResponseFormat: &openai.ChatCompletionResponseFormat{
-			Type: openai.ChatCompletionResponseFormatTypeText,
+			Type: openai.ChatCompletionResponseFormatTypeJSONObject,
 		},
 		Messages: []openai.ChatCompletionMessage{
 			{
@@ -93,11 +449,11 @@ func newTextCompletionRequest(text, previousObjects string) *openai.ChatCompleti
 	}
 }
 
-func newImageCompletionRequest(base64Image string) *openai.ChatCompletionRequest {
+func newImageCompletionRequest(model string, detail openai.ImageURLDetail, text, base64Image string) *openai.ChatCompletionRequest {
 	return &openai.ChatCompletionRequest{
-		Model: openai.GPT4o,
+		Model: model,
 		ResponseFormat: &openai.ChatCompletionResponseFormat{
-			Type: openai.ChatCompletionResponseFormatTypeText,
+			Type: openai.ChatCompletionResponseFormatTypeJSONObject,
 		},
 		Messages: []openai.ChatCompletionMessage{
 			{
@@ -105,13 +461,13 @@ func newImageCompletionRequest(base64Image string) *openai.ChatCompletionRequest
 				MultiContent: []openai.ChatMessagePart{
 					{
 						Type: openai.ChatMessagePartTypeText,
-						Text: gptGuessPrompt,
+						Text: text,
 					},
 					{
 						Type: openai.ChatMessagePartTypeImageURL,
 						ImageURL: &openai.ChatMessageImageURL{
 							URL:    fmt.Sprintf("data:image/png;base64,%s", base64Image),
-							Detail: gptGuessAbility,
+							Detail: detail,
 						},
 					},
 				},
@@ -120,16 +476,12 @@ func newImageCompletionRequest(base64Image string) *openai.ChatCompletionRequest
 	}
 }
 
-func formatChallenge(gptChallenge string) (formattedChallenge string) {
-	formattedChallenge = gptChallenge
-	formattedChallenge = strings.ReplaceAll(formattedChallenge, "*", "")
-	formattedChallenge = strings.ReplaceAll(formattedChallenge, "_", "")
-	formattedChallenge = strings.ReplaceAll(formattedChallenge, "'", "")
-	formattedChallenge = strings.ReplaceAll(formattedChallenge, "\"", "")
-	return
+// formatGuess returns the guess cleaned up for display, as a question.
+func formatGuess(gptGuess string) string {
+	return cleanGuess(gptGuess) + "?"
 }
 
-func formatGuess(gptGuess string) (formattedGuess string) {
+func cleanGuess(gptGuess string) (formattedGuess string) {
 	formattedGuess = gptGuess
 
 	formattedGuess = strings.ReplaceAll(formattedGuess, "\"", "")
@@ -144,14 +496,30 @@ func formatGuess(gptGuess string) (formattedGuess string) {
 
 	formattedGuess = strings.TrimSpace(formattedGuess)
 
-	if strings.HasSuffix(formattedGuess, ".") {
-		formattedGuess = formattedGuess[:len(formattedGuess)-1]
-	}
-	formattedGuess += "?"
+	formattedGuess = strings.TrimSuffix(formattedGuess, ".")
 
-	if len(formattedGuess) > 1 {
+	if len(formattedGuess) > 0 {
 		formattedGuess = strings.ToUpper(formattedGuess[0:1]) + formattedGuess[1:]
 	}
 
 	return
 }
+
+/******************************************************************************
+ headerTransport
+******************************************************************************/
+
+// headerTransport adds headers to every request, for those headers not
+// supported by openai.ClientConfig (like the project ID).
+type headerTransport struct {
+	base    http.RoundTripper
+	headers http.Header
+}
+
+func (t *headerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	for key, values := range t.headers {
+		req.Header[key] = values
+	}
+	return t.base.RoundTrip(req)
+}
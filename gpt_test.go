@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/sashabaranov/go-openai"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+/******************************************************************************
+ Test Helpers
+******************************************************************************/
+
+// fakeChatServer answers chat completion requests with the given contents in
+// order, recording each request and when it was made, by the clock if set.
+type fakeChatServer struct {
+	*httptest.Server
+
+	clock    clock
+	contents []string
+	requests []openai.ChatCompletionRequest
+	times    []time.Time
+
+	stateMutex sync.Mutex
+}
+
+func newFakeChatServer(t *testing.T, contents ...string) *fakeChatServer {
+	t.Helper()
+	s := &fakeChatServer{contents: contents}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req openai.ChatCompletionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		s.stateMutex.Lock()
+		s.requests = append(s.requests, req)
+		now := time.Now()
+		if s.clock != nil {
+			now = s.clock.Now()
+		}
+		s.times = append(s.times, now)
+		content := s.contents[0]
+		if len(s.contents) > 1 {
+			s.contents = s.contents[1:]
+		}
+		s.stateMutex.Unlock()
+
+		_ = json.NewEncoder(w).Encode(openai.ChatCompletionResponse{
+			Choices: []openai.ChatCompletionChoice{
+				{Message: openai.ChatCompletionMessage{Role: openai.ChatMessageRoleAssistant, Content: content}},
+			},
+		})
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestGptChallengeGenerator(t *testing.T, server *fakeChatServer, scheduler *RequestScheduler, attempts int) *GptChallengeGenerator {
+	t.Helper()
+	client, err := newGptClient(&GptConfig{APIType: gptAPITypeOpenAI, BaseURL: server.URL, Model: "test"}, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewGptChallengeGenerator(client, "test", loadTestWordBank(t), scheduler, 30, attempts)
+}
+
+/******************************************************************************
+ GptChallengeGenerator Tests
+******************************************************************************/
+
+func TestGptChallengeGeneratorSchedulesEachAttempt(t *testing.T) {
+	server := newFakeChatServer(t,
+		`{"color": "Red", "object": "big red ball", "difficulty": "Easy"}`,
+		`{"color": "Red", "object": "cats", "difficulty": "Easy"}`,
+		`{"color": "Blue", "object": "kite", "difficulty": "Easy"}`)
+
+	interval := 50 * time.Millisecond
+	scheduler, clock := newTestRequestScheduler(SchedulerConfig{MinInterval: interval, FailureThreshold: 3, OpenDuration: time.Second},
+		RetryPolicy{MaxAttempts: 1})
+	server.clock = clock
+	generator := newTestGptChallengeGenerator(t, server, scheduler, 3)
+
+	challenge, err := generator.NextChallenge(context.Background(), Easy, []string{"cat"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if challenge.String() != "Blue kite" {
+		t.Errorf("got %q, want %q", challenge, "Blue kite")
+	}
+
+	if len(server.requests) != 3 {
+		t.Fatalf("got %d requests, want 3", len(server.requests))
+	}
+
+	// The interval is kept between the calls, rather than all being made at
+	// once.
+	for i := 1; i < len(server.times); i++ {
+		if gap := server.times[i].Sub(server.times[i-1]); gap < interval {
+			t.Errorf("request %d made %v after the last, want about %v", i+1, gap, interval)
+		}
+	}
+
+	// Both the rule breaker and the repeat are sent back with the reason.
+	messages := server.requests[2].Messages
+	feedback := messages[len(messages)-3].Content + messages[len(messages)-1].Content
+	if !strings.Contains(feedback, "1 or 2 words") || !strings.Contains(feedback, "used already") {
+		t.Errorf("got feedback %q, want the reasons for both rejections", feedback)
+	}
+}
+
+func TestGptChallengeGeneratorAttempts(t *testing.T) {
+	server := newFakeChatServer(t, `{"color": "Red", "object": "cat", "difficulty": "Easy"}`)
+	generator := newTestGptChallengeGenerator(t, server, nil, 2)
+
+	// A repeat is accepted once out of attempts.
+	challenge, err := generator.NextChallenge(context.Background(), Easy, []string{"Cats"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if challenge.Object != "cat" || len(server.requests) != 2 {
+		t.Errorf("got %q after %d requests, want the repeat after 2", challenge.Object, len(server.requests))
+	}
+
+	// A response breaking the rules is not.
+	server = newFakeChatServer(t, `{"color": "Red", "object": "cat", "difficulty": "Hard"}`)
+	generator = newTestGptChallengeGenerator(t, server, nil, 2)
+	if _, err = generator.NextChallenge(context.Background(), Easy, nil); !errors.Is(err, errInvalidChallenge) {
+		t.Errorf("got error %v, want %v", err, errInvalidChallenge)
+	}
+	if len(server.requests) != 2 {
+		t.Errorf("got %d requests, want 2", len(server.requests))
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+/******************************************************************************
+ Guesser
+******************************************************************************/
+
+// Guesser implementations are shown a drawing (PNG-encoded) and attempt to
+// describe what has been drawn, ideally with 1 to 4 words like 'Orange cat'.
+// Implementations must be safe to call from a goroutine other than the one
+// running the render loop.
+type Guesser interface {
+	Guess(ctx context.Context, pngImage []byte) (*Guess, error)
+}
+
+// Guess is what a Guesser returns.  Text is the best guess, ready to be
+// displayed, Color is the main color the guesser saw (if any) and Alternates
+// are its next best guesses, best first.  Confidence runs from 0 to 1, with 0
+// also meaning unknown.  Raw holds the unmodified response from the backend,
+// for debugging purposes.
+type Guess struct {
+	Text       string
+	Color      string
+	Confidence float64
+	Alternates []string
+	Raw        string
+	Backend    string
+	Model      string
+	Latency    time.Duration
+	Tokens     int
+}
+
+// guessResponse is the JSON object guessers are asked to respond with.
+type guessResponse struct {
+	Guess      string   `json:"guess"`
+	Color      string   `json:"color"`
+	Confidence float64  `json:"confidence"`
+	Alternates []string `json:"alternates"`
+}
+
+// parseGuess returns the guess found in the raw response, which is expected
+// to be a guessResponse, keeping at most maxAlternates alternates (or all of
+// them, if negative).  Responses that are not JSON are taken as the text of
+// the guess, as given by older models and recorded guess files.
+func parseGuess(raw string, maxAlternates int) *Guess {
+	var response guessResponse
+	if err := json.Unmarshal([]byte(raw), &response); err != nil {
+		return &Guess{
+			Text: formatGuess(raw),
+			Raw:  raw,
+		}
+	}
+
+	guess := &Guess{
+		Text:       formatGuess(response.Guess),
+		Color:      cleanGuess(response.Color),
+		Confidence: math.Max(0, math.Min(1, response.Confidence)),
+		Raw:        raw,
+	}
+
+	for _, alternate := range response.Alternates {
+		if maxAlternates >= 0 && len(guess.Alternates) >= maxAlternates {
+			break
+		}
+		if alternate = cleanGuess(alternate); alternate != "" {
+			guess.Alternates = append(guess.Alternates, alternate)
+		}
+	}
+
+	return guess
+}
+
+/******************************************************************************
+ RecordedGuesser
+******************************************************************************/
+
+// RecordedGuesser ignores the drawing and replays previously recorded
+// responses, in order, looping back to the first one when exhausted.  This
+// makes it possible to exercise the game without access to a model.
+type RecordedGuesser struct {
+	responses []string
+	next      int
+	delay     time.Duration
+
+	stateMutex sync.Mutex
+}
+
+func (g *RecordedGuesser) Guess(ctx context.Context, _ []byte) (*Guess, error) {
+	start := time.Now()
+
+	if g.delay > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(g.delay):
+		}
+	}
+
+	g.stateMutex.Lock()
+	raw := g.responses[g.next]
+	g.next = (g.next + 1) % len(g.responses)
+	g.stateMutex.Unlock()
+
+	guess := parseGuess(raw, -1)
+	guess.Backend = "recorded"
+	guess.Latency = time.Since(start)
+	return guess, nil
+}
+
+// NewRecordedGuesser returns a guesser that replays the given responses,
+// waiting for the given delay before each one to mimic network latency.
+func NewRecordedGuesser(delay time.Duration, responses ...string) *RecordedGuesser {
+	if len(responses) == 0 {
+		responses = []string{""}
+	}
+
+	return &RecordedGuesser{
+		responses: responses,
+		delay:     delay,
+	}
+}
+
+// NewRecordedGuesserFromFile reads one recorded response per line from the
+// given file, either as plain text or as the JSON object asked of the GPT
+// guesser.  Blank lines are kept, as they represent an "empty" guess,
+// while lines starting with '#' are treated as comments.
+func NewRecordedGuesserFromFile(path string, delay time.Duration) (*RecordedGuesser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening recorded guesses: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	var responses []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		responses = append(responses, line)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading recorded guesses: %w", err)
+	}
+
+	if len(responses) == 0 {
+		return nil, errors.New("recorded guesses file contains no responses")
+	}
+
+	return NewRecordedGuesser(delay, responses...), nil
+}
+
+/******************************************************************************
+ ScheduledGuesser
+******************************************************************************/
+
+// ScheduledGuesser makes its guesses through a RequestScheduler, which
+// throttles and retries them along with every other model call.
+type ScheduledGuesser struct {
+	guesser   Guesser
+	scheduler *RequestScheduler
+}
+
+func (g *ScheduledGuesser) Guess(ctx context.Context, pngImage []byte) (guess *Guess, err error) {
+	err = g.scheduler.Do(ctx, func(ctx context.Context) (e error) {
+		guess, e = g.guesser.Guess(ctx, pngImage)
+		return
+	})
+	return
+}
+
+func NewScheduledGuesser(guesser Guesser, scheduler *RequestScheduler) *ScheduledGuesser {
+	return &ScheduledGuesser{
+		guesser:   guesser,
+		scheduler: scheduler,
+	}
+}
+
+/******************************************************************************
+ Guesser Functions
+******************************************************************************/
+
+func newGuesser(scheduler *RequestScheduler) (Guesser, error) {
+	var guesser Guesser
+
+	switch cfg.Guesser.Backend {
+	case "gpt":
+		client, err := newGptClient(&cfg.OpenAI, scheduler.Transport(http.DefaultTransport))
+		if err != nil {
+			return nil, err
+		}
+		guesser = NewGptGuesser(client, cfg.OpenAI.Model, cfg.Guesser.Ability, cfg.Guesser.Alternates)
+	case "recorded":
+		recorded, err := NewRecordedGuesserFromFile(cfg.Guesser.RecordedFile, cfg.Guesser.RecordedDelay)
+		if err != nil {
+			return nil, err
+		}
+		guesser = recorded
+	default:
+		return nil, fmt.Errorf("unknown guesser backend: %s", cfg.Guesser.Backend)
+	}
+
+	return NewScheduledGuesser(guesser, scheduler), nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+/******************************************************************************
+ Guess Tests
+******************************************************************************/
+
+func TestParseGuess(t *testing.T) {
+	tests := []struct {
+		name          string
+		raw           string
+		maxAlternates int
+		text, color   string
+		confidence    float64
+		alternates    []string
+	}{
+		{
+			name:          "json",
+			raw:           `{"guess": "red ball", "color": "red", "confidence": 0.8, "alternates": ["apple", "cherry"]}`,
+			maxAlternates: 2,
+			text:          "Red ball?", color: "Red", confidence: .8, alternates: []string{"Apple", "Cherry"},
+		},
+		{
+			name:          "json without extras",
+			raw:           `{"guess": "kite"}`,
+			maxAlternates: 2,
+			text:          "Kite?",
+		},
+		{
+			name:          "plain text",
+			raw:           "a red ball.",
+			maxAlternates: 2,
+			text:          "A red ball?",
+		},
+		{
+			name:          "plain text with markdown",
+			raw:           "**Blue kite drawing**",
+			maxAlternates: 2,
+			text:          "Blue kite?",
+		},
+		{
+			name:          "confidence above 1",
+			raw:           `{"guess": "sun", "confidence": 1.7}`,
+			maxAlternates: 2,
+			text:          "Sun?", confidence: 1,
+		},
+		{
+			name:          "confidence below 0",
+			raw:           `{"guess": "sun", "confidence": -0.3}`,
+			maxAlternates: 2,
+			text:          "Sun?",
+		},
+		{
+			name:          "extra alternates",
+			raw:           `{"guess": "sun", "alternates": ["moon", "star", "planet", "comet"]}`,
+			maxAlternates: 2,
+			text:          "Sun?", alternates: []string{"Moon", "Star"},
+		},
+		{
+			name:          "blank alternates",
+			raw:           `{"guess": "sun", "alternates": ["", "moon", "  ", "star", "planet"]}`,
+			maxAlternates: 2,
+			text:          "Sun?", alternates: []string{"Moon", "Star"},
+		},
+		{
+			name:          "no alternates wanted",
+			raw:           `{"guess": "sun", "alternates": ["moon"]}`,
+			maxAlternates: 0,
+			text:          "Sun?",
+		},
+		{
+			name:          "all alternates",
+			raw:           `{"guess": "sun", "alternates": ["moon", "star", "planet", "comet"]}`,
+			maxAlternates: -1,
+			text:          "Sun?", alternates: []string{"Moon", "Star", "Planet", "Comet"},
+		},
+	}
+
+	for _, test := range tests {
+		guess := parseGuess(test.raw, test.maxAlternates)
+		if guess.Text != test.text || guess.Color != test.color || guess.Confidence != test.confidence ||
+			strings.Join(guess.Alternates, ",") != strings.Join(test.alternates, ",") {
+			t.Errorf("%s: got %q (color %q, confidence %.2f, alternates %v), want %q (color %q, confidence %.2f, alternates %v)",
+				test.name, guess.Text, guess.Color, guess.Confidence, guess.Alternates,
+				test.text, test.color, test.confidence, test.alternates)
+		}
+		if guess.Raw != test.raw {
+			t.Errorf("%s: got raw %q, want %q", test.name, guess.Raw, test.raw)
+		}
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	historyFile = "history.json"
+	maxObjects  = 1000 // objects kept per player
+)
+
+/******************************************************************************
+ History Records
+******************************************************************************/
+
+// PlayerRecord is a player known to the HistoryStore.  Objects are those of
+// the challenges given to the player, oldest first, including those of rounds
+// that were never finished, so that they are not given again.
+type PlayerRecord struct {
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Objects []string  `json:"objects,omitempty"`
+}
+
+// RoundRecord is a finished game round, as kept by the HistoryStore.  Rounds
+// that were aborted are not recorded, as nothing was drawn.  InkUsed is in
+// tanks, summed across every tank of the brush, and InkCapacity is how many
+// tanks the brush had, being zero for rounds recorded before it was kept.
+type RoundRecord struct {
+	Player        string        `json:"player"`
+	Difficulty    Difficulty    `json:"difficulty"`
+	Color         string        `json:"color"`
+	Object        string        `json:"object"`
+	FinalGuess    string        `json:"finalGuess"`
+	Stars         int           `json:"stars"`
+	Points        int           `json:"points"`
+	Won           bool          `json:"won"`
+	TimeRemaining time.Duration `json:"timeRemaining"`
+	InkUsed       float64       `json:"inkUsed"`
+	InkCapacity   float64       `json:"inkCapacity,omitempty"`
+	Started       time.Time     `json:"started"`
+	Ended         time.Time     `json:"ended"`
+}
+
+func (r RoundRecord) String() string {
+	result := "timed out"
+	if r.Won {
+		result = fmt.Sprintf("won, %ds left", int(r.TimeRemaining.Seconds()))
+	}
+
+	guess := r.FinalGuess
+	if guess == "" {
+		guess = "no guess"
+	}
+
+	return fmt.Sprintf("%s  %s  %s %s: %q, %d star(s), %d points, %s, %s",
+		r.Ended.Local().Format("Jan 02 15:04"), r.Player, r.Color, r.Object, guess, r.Stars, r.Points, result, r.inkString())
+}
+
+// inkString describes the ink used as a percentage of what the brush holds
+// when full, which refills can take past 100%, or in tanks if the brush's
+// capacity was not recorded.
+func (r RoundRecord) inkString() string {
+	if r.InkCapacity > 0 {
+		return fmt.Sprintf("%.0f%% ink", r.InkUsed/r.InkCapacity*100)
+	}
+	return fmt.Sprintf("%.1f tank(s) of ink", r.InkUsed)
+}
+
+// PlayerStats summarizes the rounds a player has played.
+type PlayerStats struct {
+	Rounds int
+	Won    int
+	Stars  int
+	Points int
+}
+
+func (s PlayerStats) String() string {
+	return fmt.Sprintf("%d round(s), %d won, %d star(s), %d points", s.Rounds, s.Won, s.Stars, s.Points)
+}
+
+type historyData struct {
+	Players []PlayerRecord `json:"players"`
+	Rounds  []RoundRecord  `json:"rounds"`
+}
+
+/******************************************************************************
+ HistoryStore
+******************************************************************************/
+
+// HistoryStore keeps the players and the rounds they played in a JSON file
+// under the data directory, so that they survive restarts.  The file is
+// rewritten after every change, by way of a temporary file so that it is
+// never left half-written.
+type HistoryStore struct {
+	filename string
+	data     historyData
+
+	stateMutex sync.Mutex
+}
+
+// AddPlayer adds the player if not already known.
+func (s *HistoryStore) AddPlayer(name string) error {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	if s.addPlayer(name) {
+		return s.save()
+	}
+	return nil
+}
+
+// addPlayer must be called with the state mutex held.
+func (s *HistoryStore) addPlayer(name string) bool {
+	if s.player(name) != nil {
+		return false
+	}
+
+	s.data.Players = append(s.data.Players, PlayerRecord{
+		Name:    name,
+		Created: time.Now(),
+	})
+	return true
+}
+
+// player must be called with the state mutex held.
+func (s *HistoryStore) player(name string) *PlayerRecord {
+	for i := range s.data.Players {
+		if s.data.Players[i].Name == name {
+			return &s.data.Players[i]
+		}
+	}
+	return nil
+}
+
+// AddObject records the object of a challenge given to the player, adding the
+// player if not already known.  Only the most recent objects are kept.
+func (s *HistoryStore) AddObject(name, object string) error {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	s.addPlayer(name)
+	player := s.player(name)
+	player.Objects = append(player.Objects, object)
+	if len(player.Objects) > maxObjects {
+		player.Objects = player.Objects[len(player.Objects)-maxObjects:]
+	}
+	return s.save()
+}
+
+// Objects returns the objects of the challenges given to the player, oldest
+// first.
+func (s *HistoryStore) Objects(name string) []string {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	if player := s.player(name); player != nil {
+		return append([]string(nil), player.Objects...)
+	}
+	return nil
+}
+
+// AddRound records a finished round, adding its player if not already known.
+func (s *HistoryStore) AddRound(record RoundRecord) error {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	s.addPlayer(record.Player)
+	s.data.Rounds = append(s.data.Rounds, record)
+	return s.save()
+}
+
+// save must be called with the state mutex held.
+func (s *HistoryStore) save() error {
+	data, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error saving history: %w", err)
+	}
+
+	tempFilename := s.filename + ".tmp"
+	if err = os.WriteFile(tempFilename, data, 0660); err != nil {
+		return fmt.Errorf("error saving history: %w", err)
+	}
+
+	if err = os.Rename(tempFilename, s.filename); err != nil {
+		return fmt.Errorf("error saving history: %w", err)
+	}
+
+	return nil
+}
+
+func (s *HistoryStore) Players() []PlayerRecord {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	players := append([]PlayerRecord(nil), s.data.Players...)
+	for i := range players {
+		players[i].Objects = append([]string(nil), players[i].Objects...)
+	}
+	return players
+}
+
+// Rounds returns the recorded rounds, newest first.
+func (s *HistoryStore) Rounds() []RoundRecord {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	rounds := make([]RoundRecord, len(s.data.Rounds))
+	for i, round := range s.data.Rounds {
+		rounds[len(rounds)-1-i] = round
+	}
+	return rounds
+}
+
+func (s *HistoryStore) PlayerStats(name string) (stats PlayerStats) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	for _, round := range s.data.Rounds {
+		if round.Player != name {
+			continue
+		}
+
+		stats.Rounds++
+		stats.Stars += round.Stars
+		stats.Points += round.Points
+		if round.Won {
+			stats.Won++
+		}
+	}
+	return
+}
+
+/******************************************************************************
+ New HistoryStore Function
+******************************************************************************/
+
+// LoadHistoryStore opens the history kept in the given directory, creating
+// the directory if needed.  A missing history file is treated as empty.
+func LoadHistoryStore(directory string) (*HistoryStore, error) {
+	if err := os.MkdirAll(directory, 0770); err != nil {
+		return nil, fmt.Errorf("error creating data directory: %w", err)
+	}
+
+	s := &HistoryStore{
+		filename: path.Join(directory, historyFile),
+	}
+
+	data, err := os.ReadFile(s.filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error loading history: %w", err)
+	}
+
+	if err = json.Unmarshal(data, &s.data); err != nil {
+		return nil, fmt.Errorf("error loading history from %s: %w", s.filename, err)
+	}
+
+	return s, nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-gl/mathgl/mgl32"
+	"github.com/tonybillings/gfx"
+	"strings"
+	"sync"
+)
+
+const historyRowsPerPage = 10
+
+/******************************************************************************
+ HistoryView
+******************************************************************************/
+
+// HistoryView lists the rounds kept by a HistoryStore, newest first, a page
+// at a time, along with how many guesses this session's change detectors
+// saved.  The list is refreshed whenever the view's tab is activated.
+type HistoryView struct {
+	gfx.WindowObjectBase
+
+	store     *HistoryStore
+	player    string
+	page      int
+	detectors []namedChangeDetector
+
+	summary *gfx.Label
+	guesses *gfx.Label
+	rows    []*gfx.Label
+
+	stateMutex sync.Mutex
+}
+
+/******************************************************************************
+ Object Implementation
+******************************************************************************/
+
+func (v *HistoryView) SetEnabled(enabled bool) gfx.Object {
+	v.WindowObjectBase.SetEnabled(enabled)
+	if enabled {
+		v.refresh()
+	}
+	return v
+}
+
+/******************************************************************************
+ HistoryView Functions
+******************************************************************************/
+
+func (v *HistoryView) refresh() {
+	v.stateMutex.Lock()
+	defer v.stateMutex.Unlock()
+
+	rounds := v.store.Rounds()
+
+	pages := (len(rounds) + historyRowsPerPage - 1) / historyRowsPerPage
+	if v.page >= pages {
+		v.page = max(pages-1, 0)
+	}
+
+	summary := fmt.Sprintf("%s: %s", v.player, v.store.PlayerStats(v.player))
+	if pages > 1 {
+		summary += fmt.Sprintf("  (page %d of %d)", v.page+1, pages)
+	}
+	v.summary.SetText(summary)
+
+	guesses := make([]string, len(v.detectors))
+	for i, detector := range v.detectors {
+		guesses[i] = fmt.Sprintf("%s %s", detector.name, detector.detector)
+	}
+	v.guesses.SetText(strings.Join(guesses, "    "))
+
+	for i, row := range v.rows {
+		index := v.page*historyRowsPerPage + i
+		switch {
+		case index < len(rounds):
+			row.SetText(rounds[index].String())
+		case index == 0:
+			row.SetText("No rounds played yet")
+		default:
+			row.SetText("")
+		}
+	}
+}
+
+// turnPage moves towards older rounds if delta is positive, newer if not.
+func (v *HistoryView) turnPage(delta int) {
+	v.stateMutex.Lock()
+	v.page = max(v.page+delta, 0)
+	v.stateMutex.Unlock()
+	v.refresh()
+}
+
+// AddChangeDetector adds the detector's stats, under the given name, to those
+// shown for this session.
+func (v *HistoryView) AddChangeDetector(name string, detector *ChangeDetector) *HistoryView {
+	v.stateMutex.Lock()
+	v.detectors = append(v.detectors, namedChangeDetector{name: name, detector: detector})
+	v.stateMutex.Unlock()
+	return v
+}
+
+type namedChangeDetector struct {
+	name     string
+	detector *ChangeDetector
+}
+
+/******************************************************************************
+ New HistoryView Function
+******************************************************************************/
+
+func NewHistoryView(store *HistoryStore, player string) *HistoryView {
+	v := &HistoryView{
+		WindowObjectBase: *gfx.NewWindowObject(),
+		store:            store,
+		player:           player,
+	}
+
+	v.SetMaintainAspectRatio(false)
+
+	v.summary = gfx.NewLabel()
+	v.summary.
+		SetText("").
+		SetFontSize(.05).
+		SetAlignment(gfx.Centered).
+		SetColor(gfx.Yellow).
+		SetMaintainAspectRatio(false).
+		SetPositionY(.8)
+	v.AddChild(v.summary)
+
+	v.guesses = gfx.NewLabel()
+	v.guesses.
+		SetText("").
+		SetFontSize(.03).
+		SetAlignment(gfx.Centered).
+		SetColor(gfx.Lighten(gfx.Purple, .5)).
+		SetMaintainAspectRatio(false).
+		SetPositionY(.7)
+	v.AddChild(v.guesses)
+
+	for i := 0; i < historyRowsPerPage; i++ {
+		row := gfx.NewLabel()
+		row.
+			SetText("").
+			SetFontSize(.035).
+			SetAlignment(gfx.Centered).
+			SetColor(gfx.White).
+			SetMaintainAspectRatio(false).
+			SetPositionY(.6 - float32(i)*.13)
+		v.rows = append(v.rows, row)
+		v.AddChild(row)
+	}
+
+	newerButton := gfx.NewButton()
+	newerButton.
+		SetText("Newer").
+		SetFontSize(.5).
+		SetMouseEnterBorderColor(gfx.White).
+		SetBorderColor(gfx.Lighten(gfx.Purple, .5)).
+		SetBorderThickness(.2).
+		SetPositionX(-.15).
+		SetPositionY(-.85).
+		SetScale(mgl32.Vec3{.1, .05})
+
+	olderButton := gfx.NewButton()
+	olderButton.
+		SetText("Older").
+		SetFontSize(.5).
+		SetMouseEnterBorderColor(gfx.White).
+		SetBorderColor(gfx.Lighten(gfx.Purple, .5)).
+		SetBorderThickness(.2).
+		SetPositionX(.15).
+		SetPositionY(-.85).
+		SetScale(mgl32.Vec3{.1, .05})
+
+	newerButton.OnClick(func(_ gfx.WindowObject, _ *gfx.MouseState) {
+		v.turnPage(-1)
+	})
+	olderButton.OnClick(func(_ gfx.WindowObject, _ *gfx.MouseState) {
+		v.turnPage(1)
+	})
+
+	v.AddChildren(newerButton, olderButton)
+
+	return v
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+/******************************************************************************
+ HotSeatConfig
+******************************************************************************/
+
+// HotSeatConfig enables hot-seat mode when given 2 to 8 players, who then take
+// turns at the same canvas, each drawing the given number of rounds.
+type HotSeatConfig struct {
+	Players []string `yaml:"players"`
+	Rounds  int      `yaml:"rounds"` // rounds drawn by each player
+}
+
+func (c HotSeatConfig) Enabled() bool {
+	return len(c.Players) > 0
+}
+
+/******************************************************************************
+ PlayerScore
+******************************************************************************/
+
+type PlayerScore struct {
+	Player string
+	Points int
+	Rounds int
+}
+
+func (s PlayerScore) String() string {
+	return fmt.Sprintf("%s: %d points (%d round(s))", s.Player, s.Points, s.Rounds)
+}
+
+/******************************************************************************
+ HotSeatMatch
+******************************************************************************/
+
+// HotSeatMatch keeps track of whose turn it is and the points each player has
+// scored, for players taking turns at the same canvas.  A match is over once
+// every player has drawn the given number of rounds, at which point the
+// player(s) with the most points win.  No OpenGL is involved, so matches can
+// be exercised on their own.
+type HotSeatMatch struct {
+	scores []PlayerScore // in turn order
+	rounds int
+	turn   int // turns taken so far
+
+	stateMutex sync.Mutex
+}
+
+// CurrentPlayer returns the player whose turn it is, or would be were the
+// match not over.
+func (m *HotSeatMatch) CurrentPlayer() string {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+	return m.scores[m.turn%len(m.scores)].Player
+}
+
+// Turn returns the number of the current turn, from 1, and the number of
+// turns in the match.
+func (m *HotSeatMatch) Turn() (turn, turns int) {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+	return m.turn + 1, len(m.scores) * m.rounds
+}
+
+// Record adds the points scored by the player whose turn it is and moves on
+// to the next player, returning false if it is not the given player's turn
+// or the match is over.
+func (m *HotSeatMatch) Record(player string, points int) bool {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+
+	current := &m.scores[m.turn%len(m.scores)]
+	if m.finished() || current.Player != player {
+		return false
+	}
+
+	current.Points += points
+	current.Rounds++
+	m.turn++
+	return true
+}
+
+func (m *HotSeatMatch) Finished() bool {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+	return m.finished()
+}
+
+// finished must be called with the state mutex held.
+func (m *HotSeatMatch) finished() bool {
+	return m.turn >= len(m.scores)*m.rounds
+}
+
+// Reset starts a new match with the same players, in the same order.
+func (m *HotSeatMatch) Reset() {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+
+	m.turn = 0
+	for i := range m.scores {
+		m.scores[i].Points = 0
+		m.scores[i].Rounds = 0
+	}
+}
+
+func (m *HotSeatMatch) Players() []string {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+
+	players := make([]string, len(m.scores))
+	for i, score := range m.scores {
+		players[i] = score.Player
+	}
+	return players
+}
+
+// Scoreboard returns the players' scores, most points first, with ties left
+// in turn order.
+func (m *HotSeatMatch) Scoreboard() []PlayerScore {
+	m.stateMutex.Lock()
+	scores := append([]PlayerScore(nil), m.scores...)
+	m.stateMutex.Unlock()
+
+	sort.SliceStable(scores, func(i, j int) bool {
+		return scores[i].Points > scores[j].Points
+	})
+	return scores
+}
+
+// Winners returns the scores of the player(s) with the most points, or nil
+// if the match is not over.
+func (m *HotSeatMatch) Winners() (winners []PlayerScore) {
+	if !m.Finished() {
+		return nil
+	}
+
+	for _, score := range m.Scoreboard() {
+		if len(winners) > 0 && score.Points < winners[0].Points {
+			break
+		}
+		winners = append(winners, score)
+	}
+	return
+}
+
+/******************************************************************************
+ New HotSeatMatch Function
+******************************************************************************/
+
+// NewHotSeatMatch returns a match in which the players take turns in the
+// order given, each drawing the given number of rounds.
+func NewHotSeatMatch(players []string, rounds int) *HotSeatMatch {
+	scores := make([]PlayerScore, len(players))
+	for i, player := range players {
+		scores[i].Player = player
+	}
+
+	return &HotSeatMatch{
+		scores: scores,
+		rounds: rounds,
+	}
+}
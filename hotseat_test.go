@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+)
+
+/******************************************************************************
+ Test Helpers
+******************************************************************************/
+
+// playMatch records the given points for each turn in order, failing the
+// test if a turn is refused.
+func playMatch(t *testing.T, match *HotSeatMatch, points ...int) {
+	t.Helper()
+	for _, p := range points {
+		player := match.CurrentPlayer()
+		if !match.Record(player, p) {
+			t.Fatalf("turn of %s was refused", player)
+		}
+	}
+}
+
+func winnerNames(winners []PlayerScore) (names []string) {
+	for _, winner := range winners {
+		names = append(names, winner.Player)
+	}
+	return
+}
+
+/******************************************************************************
+ HotSeatMatch Tests
+******************************************************************************/
+
+func TestHotSeatMatchTurnOrder(t *testing.T) {
+	match := NewHotSeatMatch([]string{"Ada", "Bob", "Cy"}, 2)
+
+	want := []string{"Ada", "Bob", "Cy", "Ada", "Bob", "Cy", "Ada"}
+	for i, player := range want[:6] {
+		if turn, turns := match.Turn(); turn != i+1 || turns != 6 {
+			t.Errorf("got turn %d of %d, want %d of 6", turn, turns, i+1)
+		}
+		if got := match.CurrentPlayer(); got != player {
+			t.Fatalf("turn %d: got player %s, want %s", i+1, got, player)
+		}
+		if next := want[i+1]; match.Record(next, 10) {
+			t.Errorf("turn %d: recorded points for %s out of turn", i+1, next)
+		}
+		if !match.Record(player, 10) {
+			t.Fatalf("turn %d: turn of %s was refused", i+1, player)
+		}
+	}
+}
+
+func TestHotSeatMatchFinishes(t *testing.T) {
+	match := NewHotSeatMatch([]string{"Ada", "Bob"}, 3)
+
+	for i := 0; i < 6; i++ {
+		if match.Finished() {
+			t.Fatalf("match finished after %d of 6 turns", i)
+		}
+		if match.Winners() != nil {
+			t.Fatalf("got winners after %d of 6 turns", i)
+		}
+		playMatch(t, match, 5)
+	}
+
+	if !match.Finished() {
+		t.Fatal("match not finished after every player drew every round")
+	}
+	if player := match.CurrentPlayer(); match.Record(player, 5) {
+		t.Error("recorded points after the match was over")
+	}
+	for _, score := range match.Scoreboard() {
+		if score.Rounds != 3 || score.Points != 15 {
+			t.Errorf("got %v, want 15 points from 3 rounds", score)
+		}
+	}
+}
+
+func TestHotSeatMatchWinners(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []int // by turn, in turn order
+		want   []string
+	}{
+		{"single winner", []int{10, 30, 20}, []string{"Bob"}},
+		{"tie", []int{30, 10, 30}, []string{"Ada", "Cy"}},
+		{"tie in reverse turn order", []int{10, 30, 30}, []string{"Bob", "Cy"}},
+		{"everyone tied", []int{0, 0, 0}, []string{"Ada", "Bob", "Cy"}},
+	}
+
+	for _, test := range tests {
+		match := NewHotSeatMatch([]string{"Ada", "Bob", "Cy"}, 1)
+		playMatch(t, match, test.points...)
+
+		got := winnerNames(match.Winners())
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got winners %v, want %v", test.name, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: got winners %v, want %v", test.name, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestHotSeatMatchReset(t *testing.T) {
+	match := NewHotSeatMatch([]string{"Ada", "Bob"}, 1)
+	playMatch(t, match, 10, 20)
+
+	match.Reset()
+
+	if match.Finished() {
+		t.Fatal("match still finished after a reset")
+	}
+	if turn, _ := match.Turn(); turn != 1 {
+		t.Errorf("got turn %d after a reset, want 1", turn)
+	}
+	if player := match.CurrentPlayer(); player != "Ada" {
+		t.Errorf("got player %s after a reset, want Ada", player)
+	}
+	for _, score := range match.Scoreboard() {
+		if score.Points != 0 || score.Rounds != 0 {
+			t.Errorf("got %v after a reset, want no points or rounds", score)
+		}
+	}
+
+	playMatch(t, match, 5, 0)
+	if got := winnerNames(match.Winners()); len(got) != 1 || got[0] != "Ada" {
+		t.Errorf("got winners %v in the new match, want [Ada]", got)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"github.com/tonybillings/gfx"
+	"image/color"
+	"math"
+)
+
+/******************************************************************************
+ InkModel
+******************************************************************************/
+
+// InkTank describes one of the tanks of an InkModel, with Color being that of
+// its meter.
+type InkTank struct {
+	Name  string
+	Color color.RGBA
+}
+
+// InkModel implementations decide which ink tanks an InkBrush has, how much
+// of each tank painting a color takes and what is painted once tanks run dry.
+type InkModel interface {
+	Name() string
+	Tanks() []InkTank
+
+	// Drain returns how much of each tank painting a pixel of the given color
+	// takes, from 0 to 1, before the brush applies its drain rate.
+	Drain(c color.RGBA) []float64
+
+	// Paint returns the color actually painted given the tank levels, which
+	// will differ from the given color once its tanks run dry, or false if
+	// nothing can be painted at all.
+	Paint(c color.RGBA, levels []float64) (color.RGBA, bool)
+}
+
+/******************************************************************************
+ RGBInkModel
+******************************************************************************/
+
+// RGBInkModel is the original, additive model: red, green and blue tanks,
+// each drained in proportion to that component of the color, such that black
+// is free and white is the most expensive color to paint.
+type RGBInkModel struct{}
+
+func (m RGBInkModel) Name() string {
+	return "rgb"
+}
+
+func (m RGBInkModel) Tanks() []InkTank {
+	return []InkTank{
+		{Name: "Red", Color: gfx.Red},
+		{Name: "Green", Color: gfx.Green},
+		{Name: "Blue", Color: gfx.Blue},
+	}
+}
+
+func (m RGBInkModel) Drain(c color.RGBA) []float64 {
+	return []float64{float64(c.R) / 255, float64(c.G) / 255, float64(c.B) / 255}
+}
+
+func (m RGBInkModel) Paint(c color.RGBA, levels []float64) (color.RGBA, bool) {
+	if levels[0] <= 0 {
+		c.R = 0
+	}
+	if levels[1] <= 0 {
+		c.G = 0
+	}
+	if levels[2] <= 0 {
+		c.B = 0
+	}
+	return c, true
+}
+
+/******************************************************************************
+ CMYKInkModel
+******************************************************************************/
+
+// CMYKInkModel is a subtractive model, like a printer's cartridges: cyan,
+// magenta, yellow and key (black) tanks, drained by the amount of each ink
+// needed to lay down the color on white paper.  Black takes only key, while
+// white takes no ink at all.  Once a tank runs dry, colors are painted
+// without that ink, so that black fades to white rather than the other way
+// around.
+type CMYKInkModel struct{}
+
+func (m CMYKInkModel) Name() string {
+	return "cmyk"
+}
+
+func (m CMYKInkModel) Tanks() []InkTank {
+	return []InkTank{
+		{Name: "Cyan", Color: color.RGBA{R: 0, G: 255, B: 255, A: 255}},
+		{Name: "Magenta", Color: color.RGBA{R: 255, G: 0, B: 255, A: 255}},
+		{Name: "Yellow", Color: gfx.Yellow},
+		{Name: "Key", Color: gfx.Black},
+	}
+}
+
+func (m CMYKInkModel) Drain(c color.RGBA) []float64 {
+	cyan, magenta, yellow, key := toCMYK(c)
+	return []float64{cyan, magenta, yellow, key}
+}
+
+func (m CMYKInkModel) Paint(c color.RGBA, levels []float64) (color.RGBA, bool) {
+	inks := m.Drain(c)
+
+	needed, available := 0., 0.
+	for i, ink := range inks {
+		needed += ink
+		if levels[i] <= 0 {
+			inks[i] = 0
+		}
+		available += inks[i]
+	}
+
+	if needed > 0 && available == 0 {
+		return c, false
+	}
+
+	painted := fromCMYK(inks[0], inks[1], inks[2], inks[3])
+	painted.A = c.A
+	return painted, true
+}
+
+/******************************************************************************
+ InkConfig
+******************************************************************************/
+
+// InkConfig selects the ink model used at each difficulty.
+type InkConfig struct {
+	Easy   string `yaml:"easy"` // one of: rgb, cmyk
+	Normal string `yaml:"normal"`
+	Hard   string `yaml:"hard"`
+}
+
+/******************************************************************************
+ Ink Functions
+******************************************************************************/
+
+func toCMYK(c color.RGBA) (cyan, magenta, yellow, key float64) {
+	r, g, b := float64(c.R)/255, float64(c.G)/255, float64(c.B)/255
+
+	key = 1 - math.Max(r, math.Max(g, b))
+	if key >= 1 {
+		return 0, 0, 0, 1
+	}
+
+	cyan = (1 - r - key) / (1 - key)
+	magenta = (1 - g - key) / (1 - key)
+	yellow = (1 - b - key) / (1 - key)
+	return
+}
+
+func fromCMYK(cyan, magenta, yellow, key float64) color.RGBA {
+	return color.RGBA{
+		R: uint8(math.Round(255 * (1 - cyan) * (1 - key))),
+		G: uint8(math.Round(255 * (1 - magenta) * (1 - key))),
+		B: uint8(math.Round(255 * (1 - yellow) * (1 - key))),
+		A: 255,
+	}
+}
+
+// inkModels lists every ink model, for the brush controls to build meters for.
+var inkModels = []InkModel{RGBInkModel{}, CMYKInkModel{}}
+
+func newInkModel(name string) (InkModel, error) {
+	for _, model := range inkModels {
+		if model.Name() == name {
+			return model, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown ink model: %s", name)
+}
+
+// inkModelFor returns the ink model configured for the given difficulty,
+// which was checked when the config was loaded.
+func inkModelFor(difficulty Difficulty) InkModel {
+	name := cfg.Ink.Normal
+	switch difficulty {
+	case Easy:
+		name = cfg.Ink.Easy
+	case Hard:
+		name = cfg.Ink.Hard
+	}
+
+	if model, err := newInkModel(name); err == nil {
+		return model
+	}
+	return RGBInkModel{}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"image/color"
+	"math"
+	"testing"
+)
+
+/******************************************************************************
+ Test Helpers
+******************************************************************************/
+
+func rgb(r, g, b uint8) color.RGBA {
+	return color.RGBA{R: r, G: g, B: b, A: 255}
+}
+
+func inkLevelsEqual(a, b []float64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if math.Abs(a[i]-b[i]) > 1e-9 {
+			return false
+		}
+	}
+	return true
+}
+
+/******************************************************************************
+ CMYKInkModel Tests
+******************************************************************************/
+
+func TestCMYKInkModelDrain(t *testing.T) {
+	tests := []struct {
+		name  string
+		color color.RGBA
+		want  []float64 // cyan, magenta, yellow, key
+	}{
+		{"black", rgb(0, 0, 0), []float64{0, 0, 0, 1}},
+		{"white", rgb(255, 255, 255), []float64{0, 0, 0, 0}},
+		{"red", rgb(255, 0, 0), []float64{0, 1, 1, 0}},
+		{"cyan", rgb(0, 255, 255), []float64{1, 0, 0, 0}},
+		{"gray", rgb(51, 51, 51), []float64{0, 0, 0, .8}},
+	}
+
+	for _, test := range tests {
+		if got := (CMYKInkModel{}).Drain(test.color); !inkLevelsEqual(got, test.want) {
+			t.Errorf("%s: got drain %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestCMYKInkModelPaint(t *testing.T) {
+	full := []float64{1, 1, 1, 1}
+	noCyan := []float64{0, 1, 1, 1}
+	noKey := []float64{1, 1, 1, 0}
+	empty := []float64{0, 0, 0, 0}
+
+	tests := []struct {
+		name   string
+		color  color.RGBA
+		levels []float64
+		want   color.RGBA
+		ok     bool
+	}{
+		{"full tanks", rgb(0, 128, 0), full, rgb(0, 128, 0), true},
+		{"dark color without key", rgb(0, 0, 128), noKey, rgb(0, 0, 255), true},
+		{"gray without key", rgb(51, 51, 51), noKey, rgb(255, 255, 255), false},
+		{"green without cyan", rgb(0, 255, 0), noCyan, rgb(255, 255, 0), true},
+		{"dark green without cyan", rgb(0, 128, 0), noCyan, rgb(128, 128, 0), true},
+		{"black without key", rgb(0, 0, 0), noKey, rgb(0, 0, 0), false},
+		{"white with empty tanks", rgb(255, 255, 255), empty, rgb(255, 255, 255), true},
+	}
+
+	for _, test := range tests {
+		got, ok := (CMYKInkModel{}).Paint(test.color, test.levels)
+		if ok != test.ok || (ok && got != test.want) {
+			t.Errorf("%s: got %v (%t), want %v (%t)", test.name, got, ok, test.want, test.ok)
+		}
+	}
+}
+
+func TestCMYKRoundTrip(t *testing.T) {
+	colors := []color.RGBA{
+		rgb(0, 0, 0), rgb(255, 255, 255), rgb(255, 0, 0), rgb(0, 128, 0),
+		rgb(12, 34, 56), rgb(200, 150, 100), rgb(128, 128, 128),
+	}
+
+	for _, c := range colors {
+		if got := fromCMYK(toCMYK(c)); got != c {
+			t.Errorf("got %v back from %v", got, c)
+		}
+	}
+}
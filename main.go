@@ -2,7 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/tonybillings/gfx"
+	"github.com/tonybillings/pictionary-gpt/words"
 	"os"
 	"os/signal"
 	"syscall"
@@ -21,35 +25,123 @@ func waitForInterruptSignal(ctx context.Context, cancelFunc context.CancelFunc)
 	}
 }
 
+// fatalErrChan receives errors that should stop the app, like an invalid API
+// key; errors that may go away on their own are shown to the player instead.
+var fatalErrChan = make(chan error, 1)
+
+func reportFatalError(err error) {
+	select {
+	case fatalErrChan <- err:
+	default:
+	}
+}
+
+func waitForFatalError(ctx context.Context, cancelFunc context.CancelFunc, fatalErr *error) {
+	select {
+	case <-ctx.Done():
+		return
+	case *fatalErr = <-fatalErrChan:
+		cancelFunc()
+		return
+	}
+}
+
+// exitOnStartupErr reports an error that keeps the app from starting, such as
+// an invalid setting or a file that cannot be read, and exits.
+func exitOnStartupErr(err error) {
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "configuration error:\n%v\n", err)
+		os.Exit(2)
+	}
+}
+
 func main() {
+	config, err := loadConfig(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	exitOnStartupErr(err)
+	cfg = config
+
+	var snapshots *SnapshotStore
+	if cfg.Guesser.SaveSnapshots {
+		dir, err := prepareImageDirectory(cfg.TempDirectory)
+		exitOnStartupErr(err)
+		snapshots = NewSnapshotStore(dir, cfg.Retention)
+	}
+
+	history, err := LoadHistoryStore(cfg.DataDirectory)
+	exitOnStartupErr(err)
+	exitOnStartupErr(history.AddPlayer(cfg.Player))
+
+	wordBank, err := LoadWordBank(words.Assets, defaultWordBankFile)
+	exitOnStartupErr(err)
+
+	scheduler := NewRequestScheduler(cfg.Scheduler, cfg.Retry)
+
+	generator, err := newChallengeGenerator(wordBank, scheduler)
+	exitOnStartupErr(err)
+
+	guesser, err := newGuesser(scheduler)
+	exitOnStartupErr(err)
+
+	scorer, err := newSimilarityScorer(scheduler)
+	exitOnStartupErr(err)
+
 	panicOnErr(gfx.Init())
 	defer gfx.Close()
 
-	gfx.SetTargetFramerate(targetFramerate)
-	gfx.SetVSyncEnabled(vSyncEnabled)
+	gfx.SetTargetFramerate(uint32(cfg.Window.TargetFramerate))
+	gfx.SetVSyncEnabled(cfg.Window.VSyncEnabled)
 
 	win := gfx.NewWindow().
-		SetTitle(windowTitle).
-		SetWidth(windowWidth).
-		SetHeight(windowHeight)
+		SetTitle(cfg.Window.Title).
+		SetWidth(cfg.Window.Width).
+		SetHeight(cfg.Window.Height)
 
 	ctx, cancelFunc := context.WithCancel(context.Background())
 
-	imgDir := prepareImageDirectory(tempDirectory)
+	gameView := NewPictionaryView(win, false, generator)
+	practiceView := NewPictionaryView(win, true, nil)
 
-	gameView := NewPictionaryView(win, false)
-	practiceView := NewPictionaryView(win, true)
-	win.AddObjects(gfx.NewTabGroup(newHomeView(), practiceView, gameView))
+	for _, pictView := range []*PictionaryView{practiceView, gameView} {
+		pictView := pictView
+		pictView.
+			SetGuesser(guesser).
+			SetSimilarityScorer(scorer).
+			SetSnapshotStore(snapshots).
+			SetHistoryStore(history).
+			SetPlayer(cfg.Player)
+		scheduler.OnStatusChanged(func(status SchedulerStatus) {
+			pictView.SetStatus(status.String())
+		})
+	}
+
+	historyView := NewHistoryView(history, cfg.Player).
+		AddChangeDetector("practice", practiceView.ChangeDetector()).
+		AddChangeDetector("game", gameView.ChangeDetector())
+
+	win.AddObjects(gfx.NewTabGroup(newHomeView(), practiceView, gameView, historyView))
 
 	win.EnableQuitKey()
 	win.EnableFullscreenKey()
 
 	gfx.InitWindowAsync(win)
 
-	exportFunc := getExportFunc(gameView, imgDir)
-	guessFunc := getGuessFunc(gameView)
-	go guessRoutine(ctx, imgDir, exportFunc, guessFunc)
-
+	var fatalErr error
 	go waitForInterruptSignal(ctx, cancelFunc)
+	go waitForFatalError(ctx, cancelFunc, &fatalErr)
 	gfx.Run(ctx, cancelFunc)
+
+	if snapshots != nil {
+		if err = snapshots.Close(); err != nil {
+			_, _ = fmt.Fprintln(os.Stderr, err)
+		}
+	}
+
+	if fatalErr != nil {
+		gfx.Close()
+		_, _ = fmt.Fprintf(os.Stderr, "fatal error: %v\n", fatalErr)
+		os.Exit(1)
+	}
 }
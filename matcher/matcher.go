@@ -0,0 +1,330 @@
+// Package matcher decides whether a guess matches a challenge, word by word
+// rather than by substring, so that 'Redwood' does not match 'Red' while
+// 'crimson kittens' does match 'Red cat'.
+package matcher
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+/******************************************************************************
+ Result
+******************************************************************************/
+
+// Result explains how a guess was matched against a challenge.  Confidence
+// is between 0 and 1, with exact matches scoring 1, synonyms and aliases a
+// little less and fuzzy matches less again, averaged across the color and
+// every word of the object.  ObjectGuess is what remains of the guess once
+// the word that matched the color is removed, for comparing against the
+// object by other means.
+type Result struct {
+	ColorMatched  bool
+	ObjectMatched bool
+	Confidence    float64
+	Reasons       []string
+	ObjectGuess   string
+}
+
+// Matched returns true if both the color and the object were matched.
+func (r Result) Matched() bool {
+	return r.ColorMatched && r.ObjectMatched
+}
+
+func (r Result) String() string {
+	return fmt.Sprintf("color: %t, object: %t, confidence: %.2f (%s)",
+		r.ColorMatched, r.ObjectMatched, r.Confidence, strings.Join(r.Reasons, "; "))
+}
+
+/******************************************************************************
+ Matcher
+******************************************************************************/
+
+const (
+	exactScore   = 1.
+	synonymScore = .9
+	aliasScore   = .9
+	fuzzyMinLen  = 6 // shorter words are never fuzzy-matched, as 'horse' is one letter from 'house'
+)
+
+// Matcher matches guesses against challenges.  Words are compared after
+// tokenizing and stemming, then by way of the synonym and color alias tables
+// and finally, if enabled, by edit distance.  Colors are never matched by
+// edit distance, being short and close to other words ('Block' for Black),
+// nor is any word of the guess found in the matcher's vocabulary, as it
+// names something else rather than misspelling the object.  It is safe for
+// concurrent use.
+type Matcher struct {
+	colorAliases map[string]map[string]bool // color -> aliases
+	synonyms     map[string]map[string]bool // word -> words with the same meaning
+	vocabulary   map[string]bool            // words never taken for a typo
+	maxDistance  int
+
+	stateMutex sync.RWMutex
+}
+
+// Match compares the guess against the challenge's color and object.  A word
+// of the guess used to match the color will not also be used to match the
+// object.
+func (m *Matcher) Match(color, object, guess string) Result {
+	m.stateMutex.RLock()
+	defer m.stateMutex.RUnlock()
+
+	var result Result
+	guessWords := stemAll(Tokenize(guess))
+	colorWord := strings.ToLower(strings.TrimSpace(color))
+
+	colorIndex, colorScore, reason := -1, 0., ""
+	for i, word := range guessWords {
+		if score, why := m.matchColor(colorWord, word); score > colorScore {
+			colorIndex, colorScore, reason = i, score, why
+		}
+	}
+
+	if colorIndex >= 0 {
+		result.ColorMatched = true
+		result.Reasons = append(result.Reasons, reason)
+	} else {
+		result.Reasons = append(result.Reasons, fmt.Sprintf("no color matching %q", color))
+	}
+
+	objectWords := stemAll(Tokenize(object))
+	remaining := make([]string, 0, len(guessWords))
+	for i, word := range guessWords {
+		if i != colorIndex {
+			remaining = append(remaining, word)
+		}
+	}
+	result.ObjectGuess = strings.Join(remaining, " ")
+
+	objectScore := 0.
+	if len(objectWords) > 0 && m.matchCompound(objectWords, remaining) {
+		result.ObjectMatched = true
+		objectScore = exactScore * float64(len(objectWords))
+		result.Reasons = append(result.Reasons, fmt.Sprintf("%q matched as a compound word", object))
+	} else if len(objectWords) > 0 {
+		result.ObjectMatched = true
+		for _, objectWord := range objectWords {
+			best, why := 0., ""
+			for _, word := range remaining {
+				if score, w := m.matchWord(objectWord, word); score > best {
+					best, why = score, w
+				}
+			}
+
+			if best == 0 {
+				result.ObjectMatched = false
+				result.Reasons = append(result.Reasons, fmt.Sprintf("no word matching %q", objectWord))
+				continue
+			}
+
+			objectScore += best
+			result.Reasons = append(result.Reasons, why)
+		}
+	}
+
+	result.Confidence = (colorScore + objectScore) / float64(1+len(objectWords))
+	return result
+}
+
+// matchColor must be called with the state mutex held.
+func (m *Matcher) matchColor(color, word string) (score float64, reason string) {
+	switch {
+	case word == color:
+		return exactScore, fmt.Sprintf("%q is the color", word)
+	case m.colorAliases[color][word]:
+		return aliasScore, fmt.Sprintf("%q is another name for %s", word, color)
+	}
+
+	return 0, ""
+}
+
+// matchWord must be called with the state mutex held.
+func (m *Matcher) matchWord(target, word string) (score float64, reason string) {
+	switch {
+	case word == target:
+		return exactScore, fmt.Sprintf("%q matched", word)
+	case m.synonyms[target][word]:
+		return synonymScore, fmt.Sprintf("%q is a synonym of %q", word, target)
+	}
+
+	if d, ok := m.fuzzy(target, word); ok {
+		return fuzzyScore(target, d), fmt.Sprintf("%q is %d letter(s) from %q", word, d, target)
+	}
+
+	return 0, ""
+}
+
+// matchCompound returns true if the object's words, run together, equal a
+// run of the guess's words run together, so that 'treehouse' matches
+// 'tree house' and vice versa.  Matching the words one for one is left to
+// the caller.
+func (m *Matcher) matchCompound(objectWords, guessWords []string) bool {
+	target := strings.Join(objectWords, "")
+	for i := range guessWords {
+		joined := ""
+		for j := i; j < len(guessWords) && len(joined) < len(target); j++ {
+			joined += guessWords[j]
+			if joined == target && (len(objectWords) > 1 || j > i) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fuzzy must be called with the state mutex held.
+func (m *Matcher) fuzzy(target, word string) (distance int, ok bool) {
+	if m.maxDistance <= 0 || len(target) < fuzzyMinLen || len(word) < fuzzyMinLen || m.vocabulary[word] {
+		return 0, false
+	}
+
+	distance = editDistance(target, word)
+	return distance, distance <= m.maxDistance
+}
+
+// AddColorAliases adds other names for the given color.  An alias names a
+// single color, so one already given to another color is moved to this one.
+func (m *Matcher) AddColorAliases(color string, aliases ...string) *Matcher {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+
+	color = strings.ToLower(color)
+	if m.colorAliases[color] == nil {
+		m.colorAliases[color] = make(map[string]bool)
+	}
+
+	m.vocabulary[Stem(color)] = true
+	for _, alias := range aliases {
+		alias = Stem(strings.ToLower(alias))
+		for _, other := range m.colorAliases {
+			delete(other, alias)
+		}
+		m.colorAliases[color][alias] = true
+		m.vocabulary[alias] = true
+	}
+
+	return m
+}
+
+// AddSynonyms adds words that all mean the same thing.
+func (m *Matcher) AddSynonyms(words ...string) *Matcher {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+
+	stems := stemAll(words)
+	for _, word := range stems {
+		m.vocabulary[word] = true
+		if m.synonyms[word] == nil {
+			m.synonyms[word] = make(map[string]bool)
+		}
+		for _, synonym := range stems {
+			if synonym != word {
+				m.synonyms[word][synonym] = true
+			}
+		}
+	}
+
+	return m
+}
+
+// AddVocabulary adds words that are never taken for a typo of another word,
+// besides those of the synonym and color alias tables.
+func (m *Matcher) AddVocabulary(words ...string) *Matcher {
+	m.stateMutex.Lock()
+	defer m.stateMutex.Unlock()
+
+	for _, word := range stemAll(words) {
+		m.vocabulary[word] = true
+	}
+
+	return m
+}
+
+func (m *Matcher) MaxDistance() int {
+	m.stateMutex.RLock()
+	defer m.stateMutex.RUnlock()
+	return m.maxDistance
+}
+
+// SetMaxDistance sets how many letters may be inserted, deleted, changed or
+// swapped for words of six or more letters to still match, with 0 disabling
+// fuzzy matching.
+func (m *Matcher) SetMaxDistance(distance int) *Matcher {
+	m.stateMutex.Lock()
+	m.maxDistance = distance
+	m.stateMutex.Unlock()
+	return m
+}
+
+/******************************************************************************
+ Matcher Functions
+******************************************************************************/
+
+func stemAll(words []string) []string {
+	stems := make([]string, len(words))
+	for i, word := range words {
+		stems[i] = Stem(strings.ToLower(word))
+	}
+	return stems
+}
+
+func fuzzyScore(target string, distance int) float64 {
+	return exactScore - float64(distance)/float64(len(target))
+}
+
+// editDistance returns the number of insertions, deletions, substitutions and
+// transpositions of adjacent letters needed to turn a into b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+
+	return d[len(ra)][len(rb)]
+}
+
+/******************************************************************************
+ New Matcher Function
+******************************************************************************/
+
+// New returns a matcher loaded with the default synonym and color alias
+// tables, with fuzzy matching disabled.
+func New() *Matcher {
+	m := &Matcher{
+		colorAliases: make(map[string]map[string]bool),
+		synonyms:     make(map[string]map[string]bool),
+		vocabulary:   make(map[string]bool),
+	}
+
+	for color, aliases := range defaultColorAliases {
+		m.AddColorAliases(color, aliases...)
+	}
+
+	for word, synonyms := range defaultSynonyms {
+		m.AddSynonyms(append([]string{word}, synonyms...)...)
+	}
+
+	m.AddVocabulary(defaultVocabulary...)
+
+	return m
+}
@@ -0,0 +1,160 @@
+package matcher
+
+import (
+	"testing"
+)
+
+type matchTest struct {
+	color, object, guess string
+	colorMatched         bool
+	objectMatched        bool
+}
+
+func runMatchTests(t *testing.T, m *Matcher, tests []matchTest) {
+	t.Helper()
+	for _, test := range tests {
+		result := m.Match(test.color, test.object, test.guess)
+		if result.ColorMatched != test.colorMatched || result.ObjectMatched != test.objectMatched {
+			t.Errorf("%q for %s %s: got color %t, object %t, want color %t, object %t (%s)",
+				test.guess, test.color, test.object, result.ColorMatched, result.ObjectMatched,
+				test.colorMatched, test.objectMatched, result)
+		}
+	}
+}
+
+func TestMatchColorsExactOnly(t *testing.T) {
+	m := New().SetMaxDistance(2)
+	runMatchTests(t, m, []matchTest{
+		{"Black", "cat", "Black cat", true, true},
+		{"Black", "cat", "BLACK CAT!", true, true},
+		{"Red", "cat", "crimson cat", true, true},
+		{"Gray", "cat", "grey cat", true, true},
+		{"Black", "cat", "Block cat", false, true},
+		{"Brown", "crown", "Crown", false, true},
+		{"Green", "apple", "Greek apple", false, true},
+		{"Orange", "cat", "Orangey cat", false, true},
+	})
+}
+
+func TestMatchPluralsAndStems(t *testing.T) {
+	runMatchTests(t, New(), []matchTest{
+		{"Red", "cat", "red cats", true, true},
+		{"Blue", "box", "some blue boxes", true, true},
+		{"Red", "cherry", "a bowl of red cherries", true, true},
+		{"Green", "mouse", "green mice", true, true},
+		{"Pink", "football field", "pink football fields", true, true},
+		{"Brown", "treehouse", "a brown tree house", true, true},
+		{"Teal", "tree house", "teal treehouses", true, true},
+		{"Red", "cat", "red kittens", true, true},
+		{"Red", "cat", "red catfish", true, false},
+	})
+}
+
+func TestMatchRedwoodIsNotRed(t *testing.T) {
+	runMatchTests(t, New(), []matchTest{
+		{"Red", "tree", "Redwood tree", false, true},
+		{"Red", "tree", "red wood", true, false},
+		{"Tan", "cat", "Orange cat", false, true},
+	})
+
+	// The word matching the color is not also used for the object.
+	result := New().Match("Red", "red", "red")
+	if result.ObjectMatched {
+		t.Errorf("one word matched both the color and the object: %s", result)
+	}
+}
+
+func TestMatchSynonymsOnlyForSameThing(t *testing.T) {
+	runMatchTests(t, New(), []matchTest{
+		{"Red", "cat", "red kitty", true, true},
+		{"Blue", "sofa", "blue couch", true, true},
+		{"Red", "lamp", "red light", true, false},
+		{"Red", "heart", "red love", true, false},
+		{"Brown", "piano", "brown keyboard", true, false},
+		{"Orange", "trophy", "orange cup", true, false},
+		{"Green", "tree", "green pine", true, false},
+		{"Green", "tree", "green oak", true, false},
+		{"Purple", "butterfly", "purple moth", true, false},
+	})
+}
+
+func TestColorAliasesNameOneColor(t *testing.T) {
+	colors := make(map[string]string)
+	for color, aliases := range defaultColorAliases {
+		for _, alias := range aliases {
+			if other, ok := colors[alias]; ok {
+				t.Errorf("%q is an alias of both %s and %s", alias, other, color)
+			}
+			colors[alias] = color
+		}
+	}
+
+	runMatchTests(t, New(), []matchTest{
+		{"Orange", "cat", "amber cat", true, true},
+		{"Yellow", "cat", "amber cat", false, true},
+	})
+
+	// An alias given to another color is moved rather than shared.
+	m := New().AddColorAliases("yellow", "amber")
+	runMatchTests(t, m, []matchTest{
+		{"Yellow", "cat", "amber cat", true, true},
+		{"Orange", "cat", "amber cat", false, true},
+	})
+}
+
+func TestMatchTypos(t *testing.T) {
+	runMatchTests(t, New().SetMaxDistance(1), []matchTest{
+		{"Blue", "umbrella", "Blue umbrela", true, true},
+		{"Green", "turtle", "Green turtel", true, true},
+		{"Purple", "dragonfly", "Purple dragonflies", true, true},
+		{"Purple", "dragonfly", "Purple dragonfy", true, true},
+		{"Purple", "dragonfly", "Purple dargonfy", true, false},
+	})
+
+	// Without a distance set, as by default, typos do not match.
+	runMatchTests(t, New(), []matchTest{
+		{"Blue", "umbrella", "Blue umbrela", true, false},
+	})
+
+	result := New().SetMaxDistance(1).Match("Blue", "umbrella", "Blue umbrela")
+	if result.Confidence >= 1 || result.Confidence <= .5 {
+		t.Errorf("got confidence %.2f for a typo, want less than an exact match", result.Confidence)
+	}
+}
+
+func TestMatchNearMisses(t *testing.T) {
+	m := New().SetMaxDistance(2)
+	runMatchTests(t, m, []matchTest{
+		{"Red", "house", "Red horse", true, false},
+		{"Red", "house", "Blue mouse", false, false},
+		{"White", "table", "White cable", true, false},
+		{"Red", "cat", "Red hat", true, false},
+		{"Blue", "kitten", "Blue mitten", true, false},
+		{"Green", "castle", "Green candle", true, false},
+	})
+
+	// Words added to the vocabulary are not taken for typos either.
+	runMatchTests(t, m.AddVocabulary("rocker"), []matchTest{
+		{"Red", "rocket", "Red rocker", true, false},
+	})
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		distance int
+	}{
+		{"house", "house", 0},
+		{"house", "horse", 1},
+		{"turtle", "turtel", 1},
+		{"umbrella", "umbrela", 1},
+		{"kitten", "sitting", 3},
+		{"", "cat", 3},
+	}
+
+	for _, test := range tests {
+		if d := editDistance(test.a, test.b); d != test.distance {
+			t.Errorf("editDistance(%q, %q) = %d, want %d", test.a, test.b, d, test.distance)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package matcher
+
+// defaultColorAliases maps the colors used by the game to other names the
+// guesser may use for them, each alias naming a single color.  Aliases are
+// matched after stemming.
+var defaultColorAliases = map[string][]string{
+	"black":  {"ebony", "charcoal", "onyx"},
+	"white":  {"ivory", "snowy", "cream", "pearl"},
+	"red":    {"crimson", "scarlet", "ruby", "maroon", "burgundy", "vermilion"},
+	"green":  {"lime", "emerald", "olive", "jade", "chartreuse"},
+	"blue":   {"navy", "azure", "cobalt", "sapphire", "cerulean", "indigo"},
+	"yellow": {"gold", "golden", "lemon", "mustard", "canary"},
+	"orange": {"tangerine", "amber", "apricot", "rust", "copper"},
+	"purple": {"violet", "lavender", "lilac", "magenta", "plum", "mauve", "amethyst"},
+	"teal":   {"turquoise", "aqua", "cyan", "aquamarine"},
+	"pink":   {"fuchsia", "salmon", "blush", "rosy"},
+	"brown":  {"tan", "beige", "chocolate", "coffee", "chestnut", "bronze", "khaki", "sepia"},
+	"gray":   {"grey", "silver", "slate"},
+}
+
+// defaultSynonyms maps objects to other words that mean the same thing, for
+// the purposes of the game, rather than words for related things (a light is
+// not always a lamp, nor a cup a trophy).  Synonyms are matched after
+// stemming.
+var defaultSynonyms = map[string][]string{
+	"airplane": {"plane", "aeroplane", "jet", "aircraft"},
+	"bicycle":  {"bike", "cycle"},
+	"boat":     {"ship", "sailboat", "vessel"},
+	"car":      {"automobile", "auto"},
+	"cat":      {"kitty", "kitten"},
+	"dog":      {"puppy", "pup", "hound"},
+	"cup":      {"mug"},
+	"donut":    {"doughnut"},
+	"flower":   {"blossom", "bloom", "daisy", "tulip", "rose"},
+	"hat":      {"cap"},
+	"house":    {"home", "cottage", "hut"},
+	"moon":     {"crescent"},
+	"mountain": {"peak", "hill"},
+	"rabbit":   {"bunny", "hare"},
+	"rocket":   {"spaceship"},
+	"sofa":     {"couch"},
+	"stone":    {"rock"},
+	"sun":      {"sunshine"},
+	"whale":    {"orca"},
+	"worm":     {"earthworm"},
+	"snake":    {"serpent"},
+	"football": {"soccer"},
+	"field":    {"pitch"},
+	"robot":    {"android", "droid"},
+	"ghost":    {"spirit", "phantom"},
+	"turtle":   {"tortoise"},
+}
+
+// defaultVocabulary lists words, besides those in the tables above, that are
+// real words in their own right and so never taken for a typo of another,
+// such that 'horse' does not match 'house'.  It covers the objects of the
+// word bank and the everyday things a guesser is likely to name instead.
+var defaultVocabulary = []string{
+	"airship", "alley", "anchor", "angel", "animal", "apple", "aquarium", "arrow", "ball", "balloon",
+	"banana", "basket", "bear", "bell", "bird", "birthday", "board", "book", "bottle", "bowl",
+	"bowling", "box", "bread", "bridge", "brush", "bucket", "butter", "butterfly", "cable", "cactus",
+	"cake", "camel", "camera", "candle", "candy", "carousel", "carrot", "castle", "chair", "cherry",
+	"chess", "chicken", "circle", "circus", "city", "clock", "cloud", "coaster", "coat", "cookie",
+	"court", "cow", "crab", "crow", "crown", "cube", "curtain", "desk", "diamond", "door",
+	"dragon", "dragonfly", "duck", "eagle", "egg", "elephant", "face", "fence", "finger", "fish",
+	"flag", "fork", "fountain", "frog", "garden", "giraffe", "glass", "glove", "grand", "grape",
+	"guitar", "hammer", "hand", "heart", "horse", "island", "jacket", "jellyfish", "key", "keyboard",
+	"kite", "ladder", "lamp", "leaf", "lemon", "letter", "light", "lighthouse", "lion", "lollipop",
+	"love", "mirror", "mitten", "moat", "monkey", "moth", "mouse", "mushroom", "oak", "octopus",
+	"owl", "paper", "park", "peacock", "pear", "pencil", "pepper", "piano", "pine", "pirate",
+	"pizza", "planet", "pot", "rain", "ring", "river", "road", "roller", "sail", "scarecrow",
+	"shell", "shirt", "shoe", "skateboard", "skyline", "snowman", "sock", "spider", "spoon", "square",
+	"star", "storm", "submarine", "table", "teapot", "tennis", "tent", "tiger", "tower", "train",
+	"tree", "treehouse", "triangle", "trophy", "truck", "umbrella", "unicorn", "vehicle", "volcano", "wagon",
+	"wall", "watch", "water", "waterfall", "wheel", "windmill", "window", "wing", "zebra",
+}
@@ -0,0 +1,84 @@
+package matcher
+
+import (
+	"strings"
+	"unicode"
+)
+
+// stopWords are ignored when matching, so that 'a cat with a hat' and
+// 'cat hat' are treated the same.
+var stopWords = map[string]bool{
+	"a": true, "an": true, "the": true, "of": true, "with": true, "and": true,
+	"in": true, "on": true, "some": true, "is": true, "it": true, "its": true,
+	"this": true, "that": true, "my": true, "to": true,
+}
+
+// irregularPlurals are the plurals that Stem cannot handle by rule.
+var irregularPlurals = map[string]string{
+	"mice":     "mouse",
+	"geese":    "goose",
+	"feet":     "foot",
+	"teeth":    "tooth",
+	"men":      "man",
+	"women":    "woman",
+	"children": "child",
+	"people":   "person",
+	"leaves":   "leaf",
+	"knives":   "knife",
+	"wolves":   "wolf",
+	"loaves":   "loaf",
+	"cacti":    "cactus",
+	"octopi":   "octopus",
+	"fish":     "fish",
+	"sheep":    "sheep",
+	"deer":     "deer",
+}
+
+/******************************************************************************
+ Tokenize Functions
+******************************************************************************/
+
+// Tokenize lowercases the text and splits it into words, dropping
+// punctuation and stop words.  Words are not stemmed.
+func Tokenize(text string) []string {
+	fields := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+
+	tokens := make([]string, 0, len(fields))
+	for _, field := range fields {
+		field = strings.Trim(field, "'")
+		field = strings.TrimSuffix(field, "'s")
+		if field == "" || stopWords[field] {
+			continue
+		}
+		tokens = append(tokens, field)
+	}
+
+	return tokens
+}
+
+// Stem reduces a lowercase word to its singular form, such that 'cats',
+// 'boxes', 'cherries' and 'mice' become 'cat', 'box', 'cherry' and 'mouse'.
+// This is deliberately simple, aimed at the nouns drawn in the game rather
+// than English at large.
+func Stem(word string) string {
+	if singular, ok := irregularPlurals[word]; ok {
+		return singular
+	}
+
+	switch {
+	case len(word) > 4 && strings.HasSuffix(word, "ies"):
+		return word[:len(word)-3] + "y"
+	case len(word) > 4 && strings.HasSuffix(word, "sses"):
+		return word[:len(word)-2]
+	case len(word) > 3 && (strings.HasSuffix(word, "xes") || strings.HasSuffix(word, "zes") ||
+		strings.HasSuffix(word, "ches") || strings.HasSuffix(word, "shes")):
+		return word[:len(word)-2]
+	case len(word) > 3 && strings.HasSuffix(word, "s") &&
+		!strings.HasSuffix(word, "ss") && !strings.HasSuffix(word, "us"):
+		return word[:len(word)-1]
+	default:
+		return word
+	}
+}
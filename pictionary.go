@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/tonybillings/gfx"
+	"github.com/tonybillings/gfx/examples/ui/view"
+	"os"
+	"sync"
+)
+
+/******************************************************************************
+ PictionaryView
+******************************************************************************/
+
+// PictionaryView is the view for either practice mode or game mode.  Each
+// view owns its own capture/guess pipeline, which runs only while the view is
+// enabled, which the TabGroup does when the view's tab is active, so that only
+// the visible canvas is being guessed at.  In game mode, guessing is further
+// limited to while the player is drawing.
+type PictionaryView struct {
+	gfx.WindowObjectBase
+
+	practiceMode bool
+	session      *GameSession
+
+	captureFunc func(context.Context) (*Snapshot, error)
+	statusFunc  func(string)
+
+	guesser   Guesser
+	scorer    *SimilarityScorer
+	detector  *ChangeDetector
+	snapshots *SnapshotStore
+	history   *HistoryStore
+	player    string
+
+	scoreboard []*gfx.Label
+
+	cancelGuessing context.CancelFunc
+
+	stateMutex sync.Mutex
+}
+
+/******************************************************************************
+ Object Implementation
+******************************************************************************/
+
+func (v *PictionaryView) Close() {
+	v.stopGuessing()
+	if win := v.Window(); win != nil {
+		win.RemoveKeyEventHandlers(v)
+	}
+	v.WindowObjectBase.Close()
+}
+
+func (v *PictionaryView) SetEnabled(enabled bool) gfx.Object {
+	v.WindowObjectBase.SetEnabled(enabled)
+	v.updateGuessing()
+	return v
+}
+
+/******************************************************************************
+ PictionaryView Functions
+******************************************************************************/
+
+func (v *PictionaryView) updateGuessing() {
+	if v.Enabled() && (v.practiceMode || v.session.State() == Drawing) {
+		v.startGuessing()
+	} else {
+		v.stopGuessing()
+	}
+}
+
+func (v *PictionaryView) startGuessing() {
+	v.stateMutex.Lock()
+	defer v.stateMutex.Unlock()
+
+	if v.cancelGuessing != nil || v.guesser == nil {
+		return
+	}
+
+	ctx, cancelFunc := context.WithCancel(context.Background())
+	v.cancelGuessing = cancelFunc
+	guessFunc := getGuessFunc(v, v.session, v.scorer)
+	go guessRoutine(ctx, v.captureFunc, v.guesser, guessFunc, v.detector, v.snapshots)
+}
+
+func (v *PictionaryView) stopGuessing() {
+	v.stateMutex.Lock()
+	defer v.stateMutex.Unlock()
+
+	if v.cancelGuessing != nil {
+		v.cancelGuessing()
+		v.cancelGuessing = nil
+	}
+}
+
+// prepareScorer is called at the start of each round, in game mode, so that
+// the scorer is ready by the time the first guess is made.
+func (v *PictionaryView) prepareScorer(object string) {
+	v.stateMutex.Lock()
+	scorer := v.scorer
+	v.stateMutex.Unlock()
+
+	if scorer != nil {
+		_ = scorer.Prepare(context.Background(), object) // if it fails, it is tried again with each guess
+	}
+}
+
+// saveFinalSnapshot is called at the end of each round, in game mode.
+func (v *PictionaryView) saveFinalSnapshot() {
+	v.stateMutex.Lock()
+	snapshots := v.snapshots
+	v.stateMutex.Unlock()
+
+	if snapshots == nil {
+		return
+	}
+
+	if err := snapshots.SaveFinal(context.Background(), v.captureFunc); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// recordObject is called at the start of each round, in game mode, so that
+// the challenge is not given to the player again, even in later sessions.
+func (v *PictionaryView) recordObject(round Round) {
+	v.stateMutex.Lock()
+	history, player := v.history, v.roundPlayer(round)
+	v.stateMutex.Unlock()
+
+	if history == nil {
+		return
+	}
+
+	if err := history.AddObject(player, round.Challenge.Object); err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+// loadObjectHistory must be called with the state mutex held.  In hot-seat
+// mode, the objects given to every player are avoided, as all of them see
+// every drawing.
+func (v *PictionaryView) loadObjectHistory() {
+	if v.session == nil || v.history == nil {
+		return
+	}
+
+	players := []string{v.player}
+	if match := v.session.HotSeatMatch(); match != nil {
+		players = match.Players()
+	}
+
+	var objects []string
+	for _, player := range players {
+		objects = append(objects, v.history.Objects(player)...)
+	}
+	v.session.SetObjectHistory(objects)
+}
+
+// roundPlayer returns the player who drew the round, which in hot-seat mode
+// is recorded with it.  It must be called with the state mutex held.
+func (v *PictionaryView) roundPlayer(round Round) string {
+	if round.Player != "" {
+		return round.Player
+	}
+	return v.player
+}
+
+func (v *PictionaryView) updateScoreboard() {
+	match := v.HotSeatMatch()
+	if match == nil {
+		return
+	}
+
+	for i, score := range match.Scoreboard() {
+		v.scoreboard[i].SetText(score.String())
+	}
+}
+
+// inkUsed returns the ink used so far this round, in game mode, and how much
+// the brush holds when full, both in tanks.
+func (v *PictionaryView) inkUsed() (used, capacity float64) {
+	if brush, ok := v.Child("InkBrush").(*InkBrush); ok {
+		return brush.InkUsed(), brush.InkCapacity()
+	}
+	return 0, 0
+}
+
+// recordRound is called at the end of each round, in game mode.
+func (v *PictionaryView) recordRound(round Round, inkUsed, inkCapacity float64) {
+	v.stateMutex.Lock()
+	history, player := v.history, v.roundPlayer(round)
+	v.stateMutex.Unlock()
+
+	if history == nil {
+		return
+	}
+
+	err := history.AddRound(RoundRecord{
+		Player:        player,
+		Difficulty:    round.Difficulty,
+		Color:         round.Challenge.Color,
+		Object:        round.Challenge.Object,
+		FinalGuess:    round.FinalGuess(),
+		Stars:         round.Stars,
+		Points:        round.Score.Points,
+		Won:           round.State == Won,
+		TimeRemaining: round.TimeRemaining(),
+		InkUsed:       inkUsed,
+		InkCapacity:   inkCapacity,
+		Started:       round.Started,
+		Ended:         round.Ended,
+	})
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+	}
+}
+
+func (v *PictionaryView) PracticeMode() bool {
+	return v.practiceMode
+}
+
+// Session returns the game session, which is nil in practice mode.
+func (v *PictionaryView) Session() *GameSession {
+	return v.session
+}
+
+// HotSeatMatch returns the match being played, which is nil unless in
+// hot-seat mode.
+func (v *PictionaryView) HotSeatMatch() *HotSeatMatch {
+	if v.session == nil {
+		return nil
+	}
+	return v.session.HotSeatMatch()
+}
+
+func (v *PictionaryView) ChangeDetector() *ChangeDetector {
+	return v.detector
+}
+
+// SetGuesser sets the guesser used while the view is enabled.  If nil, no
+// guesses will be made.
+func (v *PictionaryView) SetGuesser(guesser Guesser) *PictionaryView {
+	v.stateMutex.Lock()
+	v.guesser = guesser
+	v.stateMutex.Unlock()
+	return v
+}
+
+// SetSimilarityScorer sets the scorer used to give partial credit for guesses
+// in game mode.  If nil, guesses must match the object word for word.
+func (v *PictionaryView) SetSimilarityScorer(scorer *SimilarityScorer) *PictionaryView {
+	v.stateMutex.Lock()
+	v.scorer = scorer
+	v.stateMutex.Unlock()
+	return v
+}
+
+// SetSnapshotStore sets where the snapshots sent to the guesser are saved.
+// If nil, they will not be saved.
+func (v *PictionaryView) SetSnapshotStore(snapshots *SnapshotStore) *PictionaryView {
+	v.stateMutex.Lock()
+	v.snapshots = snapshots
+	v.stateMutex.Unlock()
+	return v
+}
+
+// SetHistoryStore sets where the rounds played in game mode are recorded.
+// If nil, they will not be recorded.
+func (v *PictionaryView) SetHistoryStore(history *HistoryStore) *PictionaryView {
+	v.stateMutex.Lock()
+	v.history = history
+	v.loadObjectHistory()
+	v.stateMutex.Unlock()
+	return v
+}
+
+// SetPlayer sets the name under which rounds are recorded and loads the
+// objects of the challenges previously given to the player, so that they are
+// not given again.
+func (v *PictionaryView) SetPlayer(player string) *PictionaryView {
+	v.stateMutex.Lock()
+	v.player = player
+	v.loadObjectHistory()
+	v.stateMutex.Unlock()
+	return v
+}
+
+func (v *PictionaryView) SetStatus(status string) *PictionaryView {
+	v.statusFunc(status)
+	return v
+}
+
+/******************************************************************************
+ New PictionaryView Function
+******************************************************************************/
+
+// NewPictionaryView returns the view for either practice mode or game mode;
+// the generator is only used in game mode, so may be nil for practice mode.
+func NewPictionaryView(win *gfx.Window, practiceMode bool, generator ChallengeGenerator) *PictionaryView {
+	v := &PictionaryView{
+		WindowObjectBase: *gfx.NewWindowObject(),
+		practiceMode:     practiceMode,
+		detector:         NewChangeDetector(cfg.Guesser.ChangeThreshold),
+	}
+
+	v.SetMaintainAspectRatio(false)
+
+	status := gfx.NewLabel()
+	status.SetName("StatusLabel")
+	status.
+		SetText("").
+		SetFontSize(.03).
+		SetAlignment(gfx.Centered).
+		SetColor(gfx.Yellow).
+		SetMaintainAspectRatio(false).
+		SetAnchor(gfx.MiddleRight).
+		SetMarginRight(.01).
+		SetMarginBottom(.15).
+		SetScaleX(.3)
+
+	var canvasView gfx.WindowObject
+	if practiceMode {
+		canvasView = view.NewCanvasView()
+		canvas := canvasView.Child("Canvas").(*gfx.Canvas)
+		canvas.AddChild(NewCanvasCapture(canvas))
+	} else {
+		v.session = NewGameSession(generator, cfg.Scoring)
+		if cfg.HotSeat.Enabled() {
+			v.session.SetHotSeatMatch(NewHotSeatMatch(cfg.HotSeat.Players, cfg.HotSeat.Rounds))
+		}
+		canvasView = newGameView(win, status, v.session)
+		addUndoKeys(win, v, canvasView.Child("InkBrush").(*InkBrush), v.Enabled)
+	}
+
+	canvasView.SetPositionX(-.2)
+
+	guess1 := gfx.NewLabel()
+	guess1.SetName("GuessLabel1")
+	guess1.SetMaintainAspectRatio(false)
+	guess1.
+		SetText("").
+		SetFontSize(.045).
+		SetAlignment(gfx.Centered).
+		SetColor(gfx.White).
+		SetAnchor(gfx.MiddleRight).
+		SetMarginRight(.01).
+		SetScaleX(.3)
+
+	guess2 := gfx.NewLabel()
+	guess2.SetName("GuessLabel2")
+	guess2.
+		SetText("").
+		SetFontSize(.045).
+		SetAlignment(gfx.Centered).
+		SetColor(gfx.White).
+		SetMaintainAspectRatio(false).
+		SetAnchor(gfx.MiddleRight).
+		SetMarginRight(.01).
+		SetMarginTop(.15).
+		SetScaleX(.3)
+
+	alternates := gfx.NewLabel()
+	alternates.SetName("AlternatesLabel")
+	alternates.
+		SetText("").
+		SetFontSize(.025).
+		SetAlignment(gfx.Centered).
+		SetColor(gfx.Gray).
+		SetMaintainAspectRatio(false).
+		SetAnchor(gfx.MiddleRight).
+		SetMarginRight(.01).
+		SetMarginTop(.27).
+		SetScaleX(.3)
+
+	v.AddChildren(canvasView, status, guess1, guess2, alternates)
+
+	if match := v.HotSeatMatch(); match != nil {
+		for i := range match.Players() {
+			row := gfx.NewLabel()
+			row.SetName(fmt.Sprintf("ScoreboardLabel%d", i+1))
+			row.
+				SetText("").
+				SetFontSize(.025).
+				SetAlignment(gfx.Centered).
+				SetColor(gfx.White).
+				SetMaintainAspectRatio(false).
+				SetAnchor(gfx.MiddleRight).
+				SetMarginRight(.01).
+				SetMarginTop(.4 + float32(i)*.06).
+				SetScaleX(.3)
+			v.scoreboard = append(v.scoreboard, row)
+			v.AddChild(row)
+		}
+		v.updateScoreboard()
+	}
+
+	v.captureFunc = getCaptureFunc(v)
+	v.statusFunc = getStatusFunc(v)
+
+	if !practiceMode {
+		v.session.OnTransition(func(event RoundEvent) {
+			v.updateGuessing()
+			switch event.To {
+			case FetchingChallenge:
+				v.updateScoreboard()
+				v.detector.Reset() // the first guess of the round is never skipped
+			case Countdown:
+				go v.prepareScorer(event.Round.Challenge.Object)
+				go v.recordObject(event.Round)
+			case Won, TimedOut:
+				v.updateScoreboard()
+				go v.saveFinalSnapshot()
+				inkUsed, inkCapacity := v.inkUsed()
+				go v.recordRound(event.Round, inkUsed, inkCapacity)
+			}
+		})
+	}
+
+	return v
+}
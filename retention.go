@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	snapshotsDirectory      = "snapshots"             // holds the directory of each run, within the temp directory
+	snapshotDirectoryMarker = ".pictionary-snapshots" // marks a run's directory as made by prepareImageDirectory
+)
+
+/******************************************************************************
+ RetentionPolicy
+******************************************************************************/
+
+// RetentionPolicy limits how many of the snapshots sent to the guesser are
+// kept on disk, so that long sessions do not fill it up.  Zero values mean
+// no limit.  With FinalsOnly set, only the final snapshot of each round is
+// kept, along with the latest snapshot of the round in progress (or of the
+// practice canvas, which has no rounds).
+type RetentionPolicy struct {
+	KeepLast     int   `yaml:"keepLast"`
+	MaxBytes     int64 `yaml:"maxBytes"`
+	FinalsOnly   bool  `yaml:"finalsOnly"`
+	DeleteOnExit bool  `yaml:"deleteOnExit"`
+}
+
+/******************************************************************************
+ SnapshotStore
+******************************************************************************/
+
+// SnapshotStore saves snapshots as PNG files to a directory, deleting the
+// oldest ones as needed to satisfy its retention policy.  Snapshots that
+// were not the final snapshot of a round are deleted first.  The policy
+// applies to the snapshots of earlier runs too, as found in the sibling
+// directories of the store's directory marked as made by the app, which are
+// deleted once emptied.
+type SnapshotStore struct {
+	directory string
+	policy    RetentionPolicy
+
+	files      []storedSnapshot
+	totalBytes int64
+	sequence   int
+
+	stateMutex sync.Mutex
+}
+
+type storedSnapshot struct {
+	path  string
+	size  int64
+	final bool
+}
+
+// Save writes the snapshot to the store's directory, with final indicating
+// whether it is the last snapshot of a round.
+func (s *SnapshotStore) Save(snapshot *Snapshot, final bool) error {
+	prefix := "snapshot"
+	if final {
+		prefix = "final"
+	}
+
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	s.sequence++
+	filename := path.Join(s.directory, fmt.Sprintf("%s_%d_%04d.png", prefix, snapshot.Time.UnixMilli(), s.sequence))
+	if err := os.WriteFile(filename, snapshot.PNG, 0660); err != nil {
+		return fmt.Errorf("error saving snapshot: %w", err)
+	}
+
+	if s.policy.FinalsOnly {
+		for i := len(s.files) - 1; i >= 0; i-- {
+			if !s.files[i].final {
+				s.remove(i)
+			}
+		}
+	}
+
+	s.files = append(s.files, storedSnapshot{
+		path:  filename,
+		size:  int64(len(snapshot.PNG)),
+		final: final,
+	})
+	s.totalBytes += int64(len(snapshot.PNG))
+
+	s.prune()
+	return nil
+}
+
+// SaveFinal captures and saves the final snapshot of a round.
+func (s *SnapshotStore) SaveFinal(ctx context.Context, captureFunc func(context.Context) (*Snapshot, error)) error {
+	snapshot, err := captureFunc(ctx)
+	if err != nil {
+		return err
+	}
+	return s.Save(snapshot, true)
+}
+
+// prune must be called with the state mutex held.  The newest snapshot is
+// always kept, even if it alone exceeds MaxBytes.
+func (s *SnapshotStore) prune() {
+	for len(s.files) > 1 {
+		overCount := s.policy.KeepLast > 0 && len(s.files) > s.policy.KeepLast
+		overBytes := s.policy.MaxBytes > 0 && s.totalBytes > s.policy.MaxBytes
+		if !overCount && !overBytes {
+			return
+		}
+
+		oldest := 0
+		for i, file := range s.files[:len(s.files)-1] {
+			if !file.final {
+				oldest = i
+				break
+			}
+		}
+		s.remove(oldest)
+	}
+}
+
+func (s *SnapshotStore) remove(index int) {
+	file := s.files[index]
+	if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+		_, _ = fmt.Fprintf(os.Stderr, "error deleting snapshot: %v\n", err)
+	}
+
+	// Directories of earlier runs are removed once their last snapshot is,
+	// unless something else was put in them.
+	if dir := path.Dir(file.path); dir != s.directory {
+		if entries, err := os.ReadDir(dir); err == nil && len(entries) == 1 && entries[0].Name() == snapshotDirectoryMarker {
+			_ = os.Remove(path.Join(dir, snapshotDirectoryMarker))
+			_ = os.Remove(dir)
+		}
+	}
+
+	s.totalBytes -= file.size
+	s.files = append(s.files[:index], s.files[index+1:]...)
+}
+
+// load adds the snapshots saved by earlier runs to the store, oldest first,
+// so that they count toward, and are deleted before the store's own
+// snapshots by, the retention policy.  Only directories marked as made by
+// prepareImageDirectory are looked in.
+func (s *SnapshotStore) load() {
+	markers, err := filepath.Glob(path.Join(path.Dir(s.directory), "*", snapshotDirectoryMarker))
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "error finding snapshots: %v\n", err)
+		return
+	}
+
+	var matches []string
+	for _, marker := range markers {
+		pngs, _ := filepath.Glob(path.Join(path.Dir(marker), "*.png"))
+		matches = append(matches, pngs...)
+	}
+
+	type foundSnapshot struct {
+		storedSnapshot
+		info os.FileInfo
+	}
+
+	var found []foundSnapshot
+	for _, match := range matches {
+		name := path.Base(match)
+		final := strings.HasPrefix(name, "final_")
+		if !final && !strings.HasPrefix(name, "snapshot_") {
+			continue
+		}
+		info, err := os.Stat(match)
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		found = append(found, foundSnapshot{
+			storedSnapshot: storedSnapshot{path: match, size: info.Size(), final: final},
+			info:           info,
+		})
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		if !found[i].info.ModTime().Equal(found[j].info.ModTime()) {
+			return found[i].info.ModTime().Before(found[j].info.ModTime())
+		}
+		return found[i].path < found[j].path
+	})
+
+	for _, snapshot := range found {
+		s.files = append(s.files, snapshot.storedSnapshot)
+		s.totalBytes += snapshot.size
+	}
+}
+
+// Close deletes the store's directory, and everything in it, if the policy
+// calls for that.
+func (s *SnapshotStore) Close() error {
+	if !s.policy.DeleteOnExit {
+		return nil
+	}
+
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	s.files = nil
+	s.totalBytes = 0
+
+	if err := os.RemoveAll(s.directory); err != nil {
+		return fmt.Errorf("error deleting snapshots: %w", err)
+	}
+	return nil
+}
+
+/******************************************************************************
+ New SnapshotStore Function
+******************************************************************************/
+
+// NewSnapshotStore returns a store saving to the given directory, which is
+// expected to be a directory of its own as made by prepareImageDirectory, the
+// other directories there made likewise being those of earlier runs.
+func NewSnapshotStore(directory string, policy RetentionPolicy) *SnapshotStore {
+	store := &SnapshotStore{
+		directory: directory,
+		policy:    policy,
+	}
+	store.load()
+
+	store.stateMutex.Lock()
+	defer store.stateMutex.Unlock()
+	store.prune()
+
+	return store
+}
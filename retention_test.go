@@ -0,0 +1,183 @@
+package main
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+/******************************************************************************
+ Test Helpers
+******************************************************************************/
+
+func saveTestSnapshot(t *testing.T, store *SnapshotStore, size int, final bool) {
+	t.Helper()
+	snapshot := &Snapshot{PNG: make([]byte, size), Time: time.UnixMilli(1000)}
+	if err := store.Save(snapshot, final); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func storedSnapshots(t *testing.T, root string) []string {
+	t.Helper()
+	matches, err := filepath.Glob(path.Join(root, "*", "*.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return matches
+}
+
+// makeSessionDirectory makes a run's directory as prepareImageDirectory would,
+// but with the given name.
+func makeSessionDirectory(t *testing.T, root, name string) string {
+	t.Helper()
+	dir := makeOtherDirectory(t, root, name)
+	writeTestFile(t, path.Join(dir, snapshotDirectoryMarker))
+	return dir
+}
+
+// makeOtherDirectory makes a directory not made by the app.
+func makeOtherDirectory(t *testing.T, root, name string) string {
+	t.Helper()
+	dir := path.Join(root, name)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func writeTestFile(t *testing.T, name string) {
+	t.Helper()
+	if err := os.WriteFile(name, make([]byte, 10), 0660); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/******************************************************************************
+ SnapshotStore Tests
+******************************************************************************/
+
+func TestSnapshotStoreNamesDoNotCollide(t *testing.T) {
+	root := t.TempDir()
+	store := NewSnapshotStore(makeSessionDirectory(t, root, "1"), RetentionPolicy{})
+
+	// Every snapshot has the same time, down to the millisecond.
+	for i := 0; i < 5; i++ {
+		saveTestSnapshot(t, store, 10, false)
+	}
+	if got := len(storedSnapshots(t, root)); got != 5 {
+		t.Errorf("got %d snapshots, want 5", got)
+	}
+}
+
+func TestSnapshotStoreKeepLastSpansRuns(t *testing.T) {
+	root := t.TempDir()
+	policy := RetentionPolicy{KeepLast: 3}
+
+	earlier := NewSnapshotStore(makeSessionDirectory(t, root, "1"), policy)
+	saveTestSnapshot(t, earlier, 10, true)
+	saveTestSnapshot(t, earlier, 10, true)
+
+	// Backdate the earlier run, as file times may not tell the runs apart.
+	old := time.Now().Add(-time.Hour)
+	for _, file := range storedSnapshots(t, root) {
+		if err := os.Chtimes(file, old, old); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := NewSnapshotStore(makeSessionDirectory(t, root, "2"), policy)
+	saveTestSnapshot(t, store, 10, true)
+	saveTestSnapshot(t, store, 10, true)
+
+	files := storedSnapshots(t, root)
+	if len(files) != 3 {
+		t.Fatalf("got %d snapshots, want 3: %v", len(files), files)
+	}
+	if dir := path.Base(path.Dir(files[0])); dir != "1" {
+		t.Errorf("got oldest snapshot in %q, want the earlier run's newest", dir)
+	}
+
+	// The earlier run's directory goes once it is empty.
+	saveTestSnapshot(t, store, 10, true)
+	if _, err := os.Stat(path.Join(root, "1")); !os.IsNotExist(err) {
+		t.Errorf("earlier run's directory not removed: %v", err)
+	}
+}
+
+func TestSnapshotStoreMaxBytesSpansRuns(t *testing.T) {
+	root := t.TempDir()
+	policy := RetentionPolicy{MaxBytes: 25}
+
+	earlier := NewSnapshotStore(makeSessionDirectory(t, root, "1"), policy)
+	saveTestSnapshot(t, earlier, 10, false)
+	saveTestSnapshot(t, earlier, 10, false)
+
+	// Loading the earlier run already counts its 20 bytes.
+	store := NewSnapshotStore(makeSessionDirectory(t, root, "2"), policy)
+	saveTestSnapshot(t, store, 10, false)
+
+	files := storedSnapshots(t, root)
+	if len(files) != 2 {
+		t.Fatalf("got %d snapshots, want 2: %v", len(files), files)
+	}
+	if store.totalBytes != 20 {
+		t.Errorf("got %d bytes, want 20", store.totalBytes)
+	}
+}
+
+func TestSnapshotStorePrunesNonFinalsFirst(t *testing.T) {
+	root := t.TempDir()
+	store := NewSnapshotStore(makeSessionDirectory(t, root, "1"), RetentionPolicy{KeepLast: 2})
+
+	saveTestSnapshot(t, store, 10, true)
+	saveTestSnapshot(t, store, 10, false)
+	saveTestSnapshot(t, store, 10, false)
+
+	if len(store.files) != 2 || !store.files[0].final {
+		t.Errorf("got %+v, want the final and the newest snapshot", store.files)
+	}
+}
+
+func TestSnapshotStoreOnlyTouchesItsOwnDirectories(t *testing.T) {
+	root := t.TempDir()
+
+	// Another app's files, named like snapshots, in a shared temp directory.
+	other := makeOtherDirectory(t, root, "other")
+	writeTestFile(t, path.Join(other, "snapshot_1000_0001.png"))
+	writeTestFile(t, path.Join(other, "final_1000_0002.png"))
+
+	// An earlier run's directory, to which something else was added.
+	earlier := NewSnapshotStore(makeSessionDirectory(t, root, "1"), RetentionPolicy{})
+	saveTestSnapshot(t, earlier, 10, true)
+	writeTestFile(t, path.Join(root, "1", "notes.txt"))
+
+	store := NewSnapshotStore(makeSessionDirectory(t, root, "2"), RetentionPolicy{KeepLast: 1})
+	if len(store.files) != 1 {
+		t.Errorf("got %d snapshots loaded, want only the earlier run's: %+v", len(store.files), store.files)
+	}
+
+	saveTestSnapshot(t, store, 10, true)
+	if files := storedSnapshots(t, root); len(files) != 3 {
+		t.Errorf("got %d snapshots, want the other app's 2 and the newest: %v", len(files), files)
+	}
+	if _, err := os.Stat(path.Join(root, "1", "notes.txt")); err != nil {
+		t.Errorf("earlier run's directory removed with another file in it: %v", err)
+	}
+}
+
+func TestPrepareImageDirectory(t *testing.T) {
+	root := t.TempDir()
+	dir, err := prepareImageDirectory(root)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if path.Dir(dir) != path.Join(root, snapshotsDirectory) {
+		t.Errorf("got directory %s, want one within %s", dir, path.Join(root, snapshotsDirectory))
+	}
+	if _, err = os.Stat(path.Join(dir, snapshotDirectoryMarker)); err != nil {
+		t.Errorf("directory not marked: %v", err)
+	}
+}
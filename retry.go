@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sashabaranov/go-openai"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	quotaErrorCode = "insufficient_quota"
+)
+
+/******************************************************************************
+ RetryPolicy
+******************************************************************************/
+
+// RetryPolicy determines how often, and how quickly, failed model calls are
+// retried by the RequestScheduler.  Delays grow exponentially from BaseDelay up
+// to MaxDelay, with "full jitter" applied so that retries from the guesser and
+// the challenge generator do not line up with each other.
+type RetryPolicy struct {
+	MaxAttempts int           `yaml:"maxAttempts"`
+	BaseDelay   time.Duration `yaml:"baseDelay"`
+	MaxDelay    time.Duration `yaml:"maxDelay"`
+}
+
+func (p *RetryPolicy) delay(attempt int) time.Duration {
+	backoff := p.BaseDelay << (attempt - 1)
+	if backoff <= 0 || backoff > p.MaxDelay {
+		backoff = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+/******************************************************************************
+ Error Functions
+******************************************************************************/
+
+// isConfigurationError returns true for errors that will not go away on their
+// own, like an invalid API key or an unknown model, which should stop the app.
+func isConfigurationError(err error) bool {
+	switch httpStatusCode(err) {
+	case http.StatusUnauthorized, http.StatusForbidden, http.StatusNotFound:
+		return true
+	}
+	return isQuotaError(err)
+}
+
+func isRetryableError(err error) bool {
+	if errors.Is(err, context.Canceled) || isQuotaError(err) {
+		return false
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	switch status := httpStatusCode(err); {
+	case status == http.StatusRequestTimeout, status == http.StatusTooManyRequests:
+		return true
+	case status >= http.StatusInternalServerError:
+		return true
+	}
+
+	return false
+}
+
+// isQuotaError returns true if the account has run out of credit, which is
+// reported with the same 429 status as rate limiting but will not pass.
+func isQuotaError(err error) bool {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == quotaErrorCode || apiErr.Type == quotaErrorCode
+	}
+	return false
+}
+
+func httpStatusCode(err error) int {
+	var apiErr *openai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatusCode
+	}
+
+	var reqErr *openai.RequestError
+	if errors.As(err, &reqErr) {
+		return reqErr.HTTPStatusCode
+	}
+
+	return 0
+}
+
+// describeError returns a short description of the error, suitable for
+// displaying to the player.
+func describeError(err error) string {
+	var netErr net.Error
+	switch status := httpStatusCode(err); {
+	case isQuotaError(err):
+		return "out of credit"
+	case status == http.StatusTooManyRequests:
+		return "rate limited"
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return "access denied"
+	case status == http.StatusNotFound:
+		return "model not found"
+	case status >= http.StatusInternalServerError:
+		return fmt.Sprintf("server error (%d)", status)
+	case status > 0:
+		return fmt.Sprintf("request failed (%d)", status)
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timed out"
+	case errors.Is(err, errCircuitOpen):
+		return "temporarily unavailable"
+	case errors.Is(err, errInvalidChallenge):
+		return "invalid challenge"
+	case errors.As(err, &netErr):
+		return "network error"
+	default:
+		return "unexpected error"
+	}
+}
@@ -0,0 +1,397 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var errCircuitOpen = errors.New("AI temporarily unavailable")
+
+/******************************************************************************
+ RequestScheduler
+******************************************************************************/
+
+// RequestScheduler is shared by everything that calls a model, so that all
+// calls are throttled together.  It spaces calls out by at least the minimum
+// interval, holds them back while the server is asking us to (Retry-After and
+// the x-ratelimit-* headers), retries failed calls with exponential backoff
+// and, after repeated failures, opens a circuit so that calls fail fast until
+// the server has had some time to recover.
+type RequestScheduler struct {
+	clock            clock
+	retry            RetryPolicy
+	minInterval      time.Duration
+	failureThreshold int
+	openDuration     time.Duration
+
+	circuit      CircuitState
+	failures     int
+	openUntil    time.Time
+	probing      bool
+	blockedUntil time.Time
+	lastStart    time.Time
+	lastErr      error
+	retryAt      time.Time
+
+	onStatusChanged []func(SchedulerStatus)
+	pendingStatus   SchedulerStatus
+	statusChanged   chan struct{}
+
+	stateMutex sync.Mutex
+}
+
+// Do calls fn, once the scheduler allows it, retrying per the retry policy.
+// If the circuit is open, errCircuitOpen is returned without calling fn.
+func (s *RequestScheduler) Do(ctx context.Context, fn func(context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		if err := s.acquire(ctx); err != nil {
+			return err
+		}
+
+		err := fn(ctx)
+		s.release(err)
+
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= s.retry.MaxAttempts || !isRetryableError(err) || s.Status().Circuit == CircuitOpen {
+			return err
+		}
+
+		delay := s.retry.delay(attempt)
+		s.setRetryAt(s.clock.Now().Add(delay))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.clock.After(delay):
+		}
+	}
+}
+
+// acquire blocks until a call may be made, or returns errCircuitOpen if calls
+// are not being made at all.
+func (s *RequestScheduler) acquire(ctx context.Context) error {
+	s.stateMutex.Lock()
+
+	for {
+		now := s.clock.Now()
+
+		switch s.circuit {
+		case CircuitOpen:
+			if now.Before(s.openUntil) {
+				s.stateMutex.Unlock()
+				return errCircuitOpen
+			}
+			s.circuit = CircuitHalfOpen
+			s.probing = false
+			s.notify()
+		case CircuitHalfOpen:
+			if s.probing {
+				s.stateMutex.Unlock()
+				return errCircuitOpen
+			}
+		}
+
+		next := s.lastStart.Add(s.minInterval)
+		if s.blockedUntil.After(next) {
+			next = s.blockedUntil
+		}
+
+		if wait := next.Sub(now); wait > 0 {
+			s.stateMutex.Unlock()
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-s.clock.After(wait):
+			}
+			s.stateMutex.Lock()
+			continue
+		}
+
+		if s.circuit == CircuitHalfOpen {
+			s.probing = true // only one call is let through to test the waters
+		}
+
+		s.lastStart = now
+		s.stateMutex.Unlock()
+		return nil
+	}
+}
+
+func (s *RequestScheduler) release(err error) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
+	wasProbing := s.probing
+	s.probing = false
+	s.retryAt = time.Time{}
+
+	if err == nil {
+		changed := s.circuit != CircuitClosed || s.lastErr != nil
+		s.circuit = CircuitClosed
+		s.failures = 0
+		s.lastErr = nil
+		if changed {
+			s.notify()
+		}
+		return
+	}
+
+	if !isRetryableError(err) {
+		// the server is up, it just did not like the request
+		if wasProbing {
+			s.circuit = CircuitClosed
+			s.failures = 0
+		}
+		s.lastErr = err
+		s.notify()
+		return
+	}
+
+	s.failures++
+	s.lastErr = err
+
+	if wasProbing || s.failures >= s.failureThreshold {
+		s.circuit = CircuitOpen
+		s.openUntil = s.clock.Now().Add(s.openDuration)
+		if s.blockedUntil.After(s.openUntil) {
+			s.openUntil = s.blockedUntil
+		}
+	}
+
+	s.notify()
+}
+
+func (s *RequestScheduler) setRetryAt(retryAt time.Time) {
+	s.stateMutex.Lock()
+	s.retryAt = retryAt
+	s.notify()
+	s.stateMutex.Unlock()
+}
+
+// holdUntil stops calls from being made before the given time, as requested
+// by the server through its rate-limiting headers.
+func (s *RequestScheduler) holdUntil(until time.Time) {
+	s.stateMutex.Lock()
+	if until.After(s.blockedUntil) {
+		s.blockedUntil = until
+		s.notify()
+	}
+	s.stateMutex.Unlock()
+}
+
+// notify must be called with the state mutex held.  Handlers are called on
+// a goroutine of their own, so that they are free to call back into the
+// scheduler, which is only ever given the latest status: changes made faster
+// than they are handled are skipped rather than arriving out of order.
+func (s *RequestScheduler) notify() {
+	if len(s.onStatusChanged) == 0 {
+		return
+	}
+
+	s.pendingStatus = s.status()
+	select {
+	case s.statusChanged <- struct{}{}:
+	default: // already pending, and will be handled with the latest status
+	}
+}
+
+func (s *RequestScheduler) dispatchStatusChanges() {
+	for range s.statusChanged {
+		s.stateMutex.Lock()
+		status := s.pendingStatus
+		handlers := s.onStatusChanged
+		s.stateMutex.Unlock()
+
+		for _, handler := range handlers {
+			handler(status)
+		}
+	}
+}
+
+func (s *RequestScheduler) status() SchedulerStatus {
+	now := s.clock.Now()
+	status := SchedulerStatus{
+		Circuit:   s.circuit,
+		LastError: s.lastErr,
+	}
+
+	switch {
+	case s.circuit == CircuitOpen:
+		status.Until = s.openUntil
+	case s.retryAt.After(now):
+		status.Until = s.retryAt
+	case s.blockedUntil.After(now):
+		status.Until = s.blockedUntil
+		status.RateLimited = true
+	}
+
+	return status
+}
+
+func (s *RequestScheduler) Status() SchedulerStatus {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+	return s.status()
+}
+
+// OnStatusChanged registers a handler that will be called whenever the state
+// of the scheduler changes in a way the player may want to know about.
+func (s *RequestScheduler) OnStatusChanged(handler func(status SchedulerStatus)) {
+	s.stateMutex.Lock()
+	if s.statusChanged == nil {
+		s.statusChanged = make(chan struct{}, 1)
+		go s.dispatchStatusChanges()
+	}
+	s.onStatusChanged = append(s.onStatusChanged, handler)
+	s.stateMutex.Unlock()
+}
+
+// Transport returns an http.RoundTripper that passes the server's
+// rate-limiting headers on to the scheduler.
+func (s *RequestScheduler) Transport(base http.RoundTripper) http.RoundTripper {
+	return &rateLimitTransport{
+		base:      base,
+		scheduler: s,
+	}
+}
+
+func NewRequestScheduler(config SchedulerConfig, retry RetryPolicy) *RequestScheduler {
+	return &RequestScheduler{
+		clock:            realClock{},
+		retry:            retry,
+		minInterval:      config.MinInterval,
+		failureThreshold: config.FailureThreshold,
+		openDuration:     config.OpenDuration,
+	}
+}
+
+/******************************************************************************
+ clock
+******************************************************************************/
+
+// clock tells the time for the RequestScheduler, so that tests can control
+// it.
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+/******************************************************************************
+ SchedulerConfig
+******************************************************************************/
+
+type SchedulerConfig struct {
+	MinInterval      time.Duration `yaml:"minInterval"`      // minimum time between model calls
+	FailureThreshold int           `yaml:"failureThreshold"` // consecutive failures before the circuit opens
+	OpenDuration     time.Duration `yaml:"openDuration"`     // how long the circuit stays open
+}
+
+/******************************************************************************
+ SchedulerStatus
+******************************************************************************/
+
+type CircuitState int
+
+const (
+	CircuitClosed CircuitState = iota
+	CircuitOpen
+	CircuitHalfOpen
+)
+
+// SchedulerStatus is a snapshot of the state of a RequestScheduler.  Until is
+// when the circuit will be half-opened, when the pending retry will be made,
+// or when rate-limiting ends, depending on the state.
+type SchedulerStatus struct {
+	Circuit     CircuitState
+	Until       time.Time
+	RateLimited bool
+	LastError   error
+}
+
+// String describes the status for the player, returning an empty string when
+// everything is working as it should.
+func (s SchedulerStatus) String() string {
+	seconds := time.Until(s.Until).Seconds()
+	if seconds < 0 {
+		seconds = 0
+	}
+
+	switch {
+	case s.Circuit == CircuitOpen:
+		return fmt.Sprintf("AI unavailable (%s), back in %.0fs", describeError(s.LastError), seconds)
+	case s.Circuit == CircuitHalfOpen:
+		return "AI unavailable, checking..."
+	case !s.Until.IsZero() && s.LastError != nil:
+		return fmt.Sprintf("AI %s, retrying in %.0fs", describeError(s.LastError), seconds)
+	case s.RateLimited:
+		return fmt.Sprintf("AI rate limited, resuming in %.0fs", seconds)
+	case s.LastError != nil:
+		return fmt.Sprintf("AI unavailable (%s)", describeError(s.LastError))
+	default:
+		return ""
+	}
+}
+
+/******************************************************************************
+ rateLimitTransport
+******************************************************************************/
+
+type rateLimitTransport struct {
+	base      http.RoundTripper
+	scheduler *RequestScheduler
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	now := t.scheduler.clock.Now()
+	var until time.Time
+
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if ms, e := strconv.Atoi(resp.Header.Get("Retry-After-Ms")); e == nil {
+			until = now.Add(time.Duration(ms) * time.Millisecond)
+		} else if sec, e := strconv.Atoi(resp.Header.Get("Retry-After")); e == nil {
+			until = now.Add(time.Duration(sec) * time.Second)
+		} else if date, e := http.ParseTime(resp.Header.Get("Retry-After")); e == nil {
+			until = date
+		}
+	}
+
+	for _, limit := range []string{"requests", "tokens"} {
+		if resp.Header.Get("X-Ratelimit-Remaining-"+limit) != "0" {
+			continue
+		}
+		if reset, e := time.ParseDuration(resp.Header.Get("X-Ratelimit-Reset-" + limit)); e == nil {
+			if resetAt := now.Add(reset); resetAt.After(until) {
+				until = resetAt
+			}
+		}
+	}
+
+	if !until.IsZero() {
+		t.scheduler.holdUntil(until)
+	}
+
+	return resp, nil
+}
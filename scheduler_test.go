@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"github.com/sashabaranov/go-openai"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+/******************************************************************************
+ Test Helpers
+******************************************************************************/
+
+// fakeClock only moves when told to, or when waited on, in which case the
+// wait is over at once with the clock moved on by its duration.
+type fakeClock struct {
+	now time.Time
+
+	stateMutex sync.Mutex
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.stateMutex.Lock()
+	defer c.stateMutex.Unlock()
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.stateMutex.Lock()
+	c.now = c.now.Add(d)
+	c.stateMutex.Unlock()
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2024, 5, 24, 12, 0, 0, 0, time.UTC)}
+}
+
+func newTestRequestScheduler(config SchedulerConfig, retry RetryPolicy) (*RequestScheduler, *fakeClock) {
+	scheduler := NewRequestScheduler(config, retry)
+	clock := newFakeClock()
+	scheduler.clock = clock
+	return scheduler, clock
+}
+
+var (
+	errTestServer   = &openai.APIError{HTTPStatusCode: http.StatusInternalServerError, Message: "server error"}
+	errTestRequest  = &openai.APIError{HTTPStatusCode: http.StatusBadRequest, Message: "bad request"}
+	errTestQuota    = &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests, Code: quotaErrorCode, Type: quotaErrorCode}
+	errTestThrottle = &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests, Code: "rate_limit_exceeded"}
+)
+
+// callTimes returns a function for RequestScheduler.Do that records when it
+// was called and returns the next of the given errors, if any.
+func callTimes(clock *fakeClock, times *[]time.Time, errs ...error) func(context.Context) error {
+	return func(context.Context) error {
+		*times = append(*times, clock.Now())
+		if len(*times) <= len(errs) {
+			return errs[len(*times)-1]
+		}
+		return nil
+	}
+}
+
+/******************************************************************************
+ RequestScheduler Tests
+******************************************************************************/
+
+func TestRequestSchedulerSpacesCalls(t *testing.T) {
+	interval := 2 * time.Second
+	scheduler, clock := newTestRequestScheduler(SchedulerConfig{MinInterval: interval, FailureThreshold: 3, OpenDuration: time.Minute},
+		RetryPolicy{MaxAttempts: 1})
+
+	var times []time.Time
+	for i := 0; i < 3; i++ {
+		if err := scheduler.Do(context.Background(), callTimes(clock, &times)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	for i := 1; i < len(times); i++ {
+		if gap := times[i].Sub(times[i-1]); gap != interval {
+			t.Errorf("call %d made %v after the last, want %v", i+1, gap, interval)
+		}
+	}
+
+	// Time spent elsewhere counts toward the interval.
+	clock.Advance(interval + time.Second)
+	start := clock.Now()
+	if err := scheduler.Do(context.Background(), callTimes(clock, &times)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if last := times[len(times)-1]; !last.Equal(start) {
+		t.Errorf("call made %v after it was due, want at once", last.Sub(start))
+	}
+}
+
+func TestRequestSchedulerHoldUntil(t *testing.T) {
+	scheduler, clock := newTestRequestScheduler(SchedulerConfig{FailureThreshold: 3, OpenDuration: time.Minute},
+		RetryPolicy{MaxAttempts: 1})
+
+	until := clock.Now().Add(5 * time.Second)
+	scheduler.holdUntil(until)
+	scheduler.holdUntil(until.Add(-time.Second)) // an earlier hold does not shorten it
+
+	if status := scheduler.Status(); !status.RateLimited || !status.Until.Equal(until) {
+		t.Errorf("got status %+v, want rate limited until %v", status, until)
+	}
+
+	var times []time.Time
+	if err := scheduler.Do(context.Background(), callTimes(clock, &times)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !times[0].Equal(until) {
+		t.Errorf("call made at %v, want at %v", times[0], until)
+	}
+	if status := scheduler.Status(); status.RateLimited {
+		t.Errorf("got status %+v after the hold, want not rate limited", status)
+	}
+}
+
+func TestRequestSchedulerRetries(t *testing.T) {
+	tests := []struct {
+		name  string
+		errs  []error
+		calls int
+		err   error
+	}{
+		{"success", nil, 1, nil},
+		{"server error, then success", []error{errTestServer}, 2, nil},
+		{"rate limited, then success", []error{errTestThrottle}, 2, nil},
+		{"server errors", []error{errTestServer, errTestServer, errTestServer, errTestServer}, 3, errTestServer},
+		{"bad request", []error{errTestRequest}, 1, errTestRequest},
+		{"out of credit", []error{errTestQuota}, 1, errTestQuota},
+		{"canceled", []error{context.Canceled}, 1, context.Canceled},
+	}
+
+	for _, test := range tests {
+		scheduler, clock := newTestRequestScheduler(SchedulerConfig{FailureThreshold: 10, OpenDuration: time.Minute},
+			RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: 4 * time.Second})
+
+		var times []time.Time
+		err := scheduler.Do(context.Background(), callTimes(clock, &times, test.errs...))
+		if !errors.Is(err, test.err) || len(times) != test.calls {
+			t.Errorf("%s: got error %v after %d calls, want %v after %d", test.name, err, len(times), test.err, test.calls)
+		}
+	}
+}
+
+func TestRequestSchedulerCircuit(t *testing.T) {
+	openDuration := 30 * time.Second
+	scheduler, clock := newTestRequestScheduler(SchedulerConfig{FailureThreshold: 2, OpenDuration: openDuration},
+		RetryPolicy{MaxAttempts: 1})
+
+	var times []time.Time
+	fail := callTimes(clock, &times, errTestServer, errTestServer, errTestServer)
+
+	// A bad request says nothing of the server, so does not count.
+	_ = scheduler.Do(context.Background(), callTimes(clock, new([]time.Time), errTestRequest))
+	_ = scheduler.Do(context.Background(), fail)
+	if status := scheduler.Status(); status.Circuit != CircuitClosed {
+		t.Fatalf("got circuit %d after 1 failure, want closed", status.Circuit)
+	}
+
+	_ = scheduler.Do(context.Background(), fail)
+	status := scheduler.Status()
+	if status.Circuit != CircuitOpen || !status.Until.Equal(clock.Now().Add(openDuration)) {
+		t.Fatalf("got status %+v after 2 failures, want open for %v", status, openDuration)
+	}
+
+	// While open, calls fail fast.
+	if err := scheduler.Do(context.Background(), fail); !errors.Is(err, errCircuitOpen) || len(times) != 2 {
+		t.Errorf("got error %v after %d calls, want %v without a call", err, len(times), errCircuitOpen)
+	}
+
+	// Once the time is up a single call is let through, failing which the
+	// circuit opens again.
+	clock.Advance(openDuration)
+	if err := scheduler.Do(context.Background(), fail); !errors.Is(err, errTestServer) || len(times) != 3 {
+		t.Errorf("got error %v after %d calls, want %v from the third", err, len(times), errTestServer)
+	}
+	if status = scheduler.Status(); status.Circuit != CircuitOpen {
+		t.Fatalf("got circuit %d after a failed probe, want open", status.Circuit)
+	}
+
+	// While that call is being made, others still fail fast.
+	clock.Advance(openDuration)
+	err := scheduler.Do(context.Background(), func(ctx context.Context) error {
+		if status := scheduler.Status(); status.Circuit != CircuitHalfOpen {
+			t.Errorf("got circuit %d while probing, want half-open", status.Circuit)
+		}
+		if err := scheduler.Do(ctx, fail); !errors.Is(err, errCircuitOpen) {
+			t.Errorf("got error %v while probing, want %v", err, errCircuitOpen)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if status = scheduler.Status(); status.Circuit != CircuitClosed || status.LastError != nil {
+		t.Errorf("got status %+v after a successful probe, want closed", status)
+	}
+}
+
+func TestRequestSchedulerCircuitClosedByBadRequest(t *testing.T) {
+	scheduler, clock := newTestRequestScheduler(SchedulerConfig{FailureThreshold: 1, OpenDuration: time.Minute},
+		RetryPolicy{MaxAttempts: 1})
+
+	_ = scheduler.Do(context.Background(), callTimes(clock, new([]time.Time), errTestServer))
+	clock.Advance(time.Minute)
+
+	// The server answering at all, even with an error of the request's own
+	// making, shows it has recovered.
+	_ = scheduler.Do(context.Background(), callTimes(clock, new([]time.Time), errTestRequest))
+	if status := scheduler.Status(); status.Circuit != CircuitClosed || status.LastError != errTestRequest {
+		t.Errorf("got status %+v, want closed with the bad request", status)
+	}
+}
+
+func TestRequestSchedulerStatusChangesInOrder(t *testing.T) {
+	scheduler, clock := newTestRequestScheduler(SchedulerConfig{FailureThreshold: 1, OpenDuration: time.Minute},
+		RetryPolicy{MaxAttempts: 1})
+
+	statuses := make(chan SchedulerStatus, 100)
+	scheduler.OnStatusChanged(func(status SchedulerStatus) {
+		time.Sleep(time.Millisecond) // a slow handler, as the UI may be
+		statuses <- status
+	})
+
+	for i := 0; i < 10; i++ {
+		_ = scheduler.Do(context.Background(), callTimes(clock, new([]time.Time), errTestServer))
+		clock.Advance(time.Minute)
+		_ = scheduler.Do(context.Background(), callTimes(clock, new([]time.Time)))
+	}
+
+	// However many changes are skipped, the last one handled is the latest.
+	var last SchedulerStatus
+	for done := false; !done; {
+		select {
+		case last = <-statuses:
+		case <-time.After(100 * time.Millisecond):
+			done = true
+		}
+	}
+	if last.Circuit != CircuitClosed || last.LastError != nil {
+		t.Errorf("got last status %+v, want closed", last)
+	}
+}
+
+/******************************************************************************
+ rateLimitTransport Tests
+******************************************************************************/
+
+func TestRateLimitTransport(t *testing.T) {
+	clock := newFakeClock()
+	now := clock.Now()
+
+	tests := []struct {
+		name    string
+		status  int
+		headers map[string]string
+		hold    time.Duration // 0 for none
+	}{
+		{"ok", http.StatusOK, nil, 0},
+		{"retry after seconds", http.StatusTooManyRequests, map[string]string{"Retry-After": "3"}, 3 * time.Second},
+		{"retry after milliseconds", http.StatusTooManyRequests,
+			map[string]string{"Retry-After-Ms": "1500", "Retry-After": "3"}, 1500 * time.Millisecond},
+		{"retry after date", http.StatusServiceUnavailable,
+			map[string]string{"Retry-After": now.Add(10 * time.Second).Format(http.TimeFormat)}, 10 * time.Second},
+		{"retry after on success", http.StatusOK, map[string]string{"Retry-After": "3"}, 0},
+		{"bad retry after", http.StatusTooManyRequests, map[string]string{"Retry-After": "soon"}, 0},
+		{"requests exhausted", http.StatusOK,
+			map[string]string{"X-Ratelimit-Remaining-Requests": "0", "X-Ratelimit-Reset-Requests": "6s"}, 6 * time.Second},
+		{"tokens exhausted", http.StatusOK,
+			map[string]string{"X-Ratelimit-Remaining-Tokens": "0", "X-Ratelimit-Reset-Tokens": "250ms"}, 250 * time.Millisecond},
+		{"requests remaining", http.StatusOK,
+			map[string]string{"X-Ratelimit-Remaining-Requests": "5", "X-Ratelimit-Reset-Requests": "6s"}, 0},
+		{"longest wait", http.StatusTooManyRequests, map[string]string{
+			"Retry-After":                  "2",
+			"X-Ratelimit-Remaining-Tokens": "0", "X-Ratelimit-Reset-Tokens": "1m0s",
+			"X-Ratelimit-Remaining-Requests": "0", "X-Ratelimit-Reset-Requests": "5s",
+		}, time.Minute},
+	}
+
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			for key, value := range test.headers {
+				w.Header().Set(key, value)
+			}
+			w.WriteHeader(test.status)
+		}))
+
+		scheduler := NewRequestScheduler(SchedulerConfig{FailureThreshold: 1, OpenDuration: time.Minute}, RetryPolicy{MaxAttempts: 1})
+		scheduler.clock = clock
+
+		client := &http.Client{Transport: scheduler.Transport(http.DefaultTransport)}
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", test.name, err)
+		}
+		_ = resp.Body.Close()
+		server.Close()
+
+		var want time.Time
+		if test.hold > 0 {
+			want = now.Add(test.hold)
+		}
+		if got := scheduler.blockedUntil; !got.Equal(want) {
+			t.Errorf("%s: got hold until %v, want %v", test.name, got, want)
+		}
+	}
+}
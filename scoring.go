@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+/******************************************************************************
+ ScoringConfig
+******************************************************************************/
+
+// ScoringConfig sets how many points a round is worth.  Every star earned is
+// worth PointsPerStar.  Winning also earns up to TimeBonus, in proportion to
+// the time remaining, and up to InkBonus, in proportion to the ink left
+// unused.  Every guess after the first costs GuessPenalty.  The sum is then
+// multiplied by the multiplier for the round's difficulty.
+type ScoringConfig struct {
+	PointsPerStar    int     `yaml:"pointsPerStar"`
+	TimeBonus        int     `yaml:"timeBonus"`
+	InkBonus         int     `yaml:"inkBonus"`
+	GuessPenalty     int     `yaml:"guessPenalty"`
+	EasyMultiplier   float64 `yaml:"easyMultiplier"`
+	NormalMultiplier float64 `yaml:"normalMultiplier"`
+	HardMultiplier   float64 `yaml:"hardMultiplier"`
+}
+
+func (c ScoringConfig) multiplier(difficulty Difficulty) float64 {
+	switch difficulty {
+	case Easy:
+		return c.EasyMultiplier
+	case Normal:
+		return c.NormalMultiplier
+	case Hard:
+		return c.HardMultiplier
+	default:
+		return 1
+	}
+}
+
+/******************************************************************************
+ Score
+******************************************************************************/
+
+// Score breaks down the points earned for a round.  Points is the total,
+// which is never negative.
+type Score struct {
+	StarPoints   int
+	TimeBonus    int
+	InkBonus     int
+	GuessPenalty int
+	Multiplier   float64
+	Points       int
+}
+
+func (s Score) String() string {
+	return fmt.Sprintf("%d points (%d for stars + %d for time + %d for ink - %d for guesses, x%.1f)",
+		s.Points, s.StarPoints, s.TimeBonus, s.InkBonus, s.GuessPenalty, s.Multiplier)
+}
+
+/******************************************************************************
+ Scoring Functions
+******************************************************************************/
+
+// scoreRound scores a finished round, given the fraction of the brush's ink
+// that was used, from 0 to 1.
+func scoreRound(config ScoringConfig, round *Round, inkUsed float64) (score Score) {
+	score.StarPoints = round.Stars * config.PointsPerStar
+	score.Multiplier = config.multiplier(round.Difficulty)
+
+	if round.State == Won {
+		if round.TimeAllowed > 0 {
+			timeLeft := float64(round.TimeRemaining()) / float64(round.TimeAllowed)
+			score.TimeBonus = int(math.Round(float64(config.TimeBonus) * timeLeft))
+		}
+
+		inkLeft := math.Max(0, math.Min(1, 1-inkUsed))
+		score.InkBonus = int(math.Round(float64(config.InkBonus) * inkLeft))
+	}
+
+	if len(round.Guesses) > 1 {
+		score.GuessPenalty = (len(round.Guesses) - 1) * config.GuessPenalty
+	}
+
+	points := float64(score.StarPoints+score.TimeBonus+score.InkBonus-score.GuessPenalty) * score.Multiplier
+	score.Points = max(int(math.Round(points)), 0)
+	return
+}
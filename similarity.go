@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+/******************************************************************************
+ Embedder
+******************************************************************************/
+
+// Embedder implementations turn text into vectors such that texts with
+// similar meanings have similar vectors.  The vectors returned are in the
+// same order as the texts given.  Implementations must be safe to call from
+// a goroutine other than the one running the render loop.
+type Embedder interface {
+	Embed(ctx context.Context, texts ...string) ([][]float32, error)
+}
+
+/******************************************************************************
+ ScheduledEmbedder
+******************************************************************************/
+
+// ScheduledEmbedder makes its requests through a RequestScheduler, which
+// throttles and retries them along with every other model call.
+type ScheduledEmbedder struct {
+	embedder  Embedder
+	scheduler *RequestScheduler
+}
+
+func (e *ScheduledEmbedder) Embed(ctx context.Context, texts ...string) (vectors [][]float32, err error) {
+	err = e.scheduler.Do(ctx, func(ctx context.Context) (err error) {
+		vectors, err = e.embedder.Embed(ctx, texts...)
+		return
+	})
+	return
+}
+
+func NewScheduledEmbedder(embedder Embedder, scheduler *RequestScheduler) *ScheduledEmbedder {
+	return &ScheduledEmbedder{
+		embedder:  embedder,
+		scheduler: scheduler,
+	}
+}
+
+/******************************************************************************
+ SimilarityScorer
+******************************************************************************/
+
+// SimilarityScorer rates how close in meaning a guess is to the object of a
+// challenge, from 0 to 1, by the cosine similarity of their embeddings.  The
+// embeddings of objects are cached, so that only the guess needs embedding
+// once Prepare has been called for the round's object.
+type SimilarityScorer struct {
+	embedder Embedder
+	cache    map[string][]float32
+
+	stateMutex sync.Mutex
+}
+
+// Prepare embeds the object ahead of time, so that scoring guesses against it
+// takes one request instead of two.
+func (s *SimilarityScorer) Prepare(ctx context.Context, object string) error {
+	_, err := s.objectEmbedding(ctx, object)
+	return err
+}
+
+func (s *SimilarityScorer) Score(ctx context.Context, object, guess string) (float64, error) {
+	objectVector, err := s.objectEmbedding(ctx, object)
+	if err != nil {
+		return 0, err
+	}
+
+	vectors, err := s.embedder.Embed(ctx, strings.ToLower(guess))
+	if err != nil {
+		return 0, err
+	}
+
+	if len(vectors) != 1 {
+		return 0, errors.New("embedding response contained no vectors")
+	}
+
+	return cosineSimilarity(objectVector, vectors[0]), nil
+}
+
+func (s *SimilarityScorer) objectEmbedding(ctx context.Context, object string) ([]float32, error) {
+	object = strings.ToLower(object)
+
+	s.stateMutex.Lock()
+	vector, ok := s.cache[object]
+	s.stateMutex.Unlock()
+
+	if ok {
+		return vector, nil
+	}
+
+	vectors, err := s.embedder.Embed(ctx, object)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(vectors) != 1 {
+		return nil, errors.New("embedding response contained no vectors")
+	}
+
+	s.stateMutex.Lock()
+	s.cache[object] = vectors[0]
+	s.stateMutex.Unlock()
+
+	return vectors[0], nil
+}
+
+func NewSimilarityScorer(embedder Embedder) *SimilarityScorer {
+	return &SimilarityScorer{
+		embedder: embedder,
+		cache:    make(map[string][]float32),
+	}
+}
+
+/******************************************************************************
+ SimilarityConfig
+******************************************************************************/
+
+// SimilarityConfig controls the optional partial credit given to guesses that
+// are close in meaning to the object, without matching it word for word.  A
+// guess scoring at least PartialThreshold earns a star, while one scoring at
+// least FullThreshold is treated as having named the object.
+type SimilarityConfig struct {
+	Backend          string  `yaml:"backend"` // one of: none, gpt
+	Model            string  `yaml:"model"`
+	PartialThreshold float64 `yaml:"partialThreshold"`
+	FullThreshold    float64 `yaml:"fullThreshold"`
+}
+
+// credit returns the stars earned for the object by a guess with the given
+// similarity to it, and whether the guess counts as having named it.
+func (c *SimilarityConfig) credit(similarity float64) (stars int, named bool) {
+	switch {
+	case similarity >= c.FullThreshold:
+		return 2, true
+	case similarity >= c.PartialThreshold:
+		return 1, false
+	default:
+		return 0, false
+	}
+}
+
+/******************************************************************************
+ Similarity Functions
+******************************************************************************/
+
+func cosineSimilarity(a, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		normA += float64(a[i]) * float64(a[i])
+		normB += float64(b[i]) * float64(b[i])
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// newSimilarityScorer returns nil if similarity scoring is disabled.
+func newSimilarityScorer(scheduler *RequestScheduler) (*SimilarityScorer, error) {
+	switch cfg.Similarity.Backend {
+	case "none":
+		return nil, nil
+	case "gpt":
+		client, err := newGptClient(&cfg.OpenAI, scheduler.Transport(http.DefaultTransport))
+		if err != nil {
+			return nil, err
+		}
+		embedder := NewGptEmbedder(client, cfg.Similarity.Model)
+		return NewSimilarityScorer(NewScheduledEmbedder(embedder, scheduler)), nil
+	default:
+		return nil, fmt.Errorf("unknown similarity backend: %s", cfg.Similarity.Backend)
+	}
+}
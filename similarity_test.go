@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"testing"
+)
+
+/******************************************************************************
+ Test Helpers
+******************************************************************************/
+
+// fakeEmbedder returns the vector given for each text, counting the texts it
+// was asked to embed.
+type fakeEmbedder struct {
+	vectors map[string][]float32
+	err     error
+	calls   map[string]int
+
+	stateMutex sync.Mutex
+}
+
+func (e *fakeEmbedder) Embed(_ context.Context, texts ...string) ([][]float32, error) {
+	e.stateMutex.Lock()
+	defer e.stateMutex.Unlock()
+
+	if e.err != nil {
+		return nil, e.err
+	}
+
+	var vectors [][]float32
+	for _, text := range texts {
+		e.calls[text]++
+		if vector, ok := e.vectors[text]; ok {
+			vectors = append(vectors, vector)
+		}
+	}
+	return vectors, nil
+}
+
+func newFakeEmbedder(vectors map[string][]float32) *fakeEmbedder {
+	return &fakeEmbedder{vectors: vectors, calls: make(map[string]int)}
+}
+
+/******************************************************************************
+ SimilarityScorer Tests
+******************************************************************************/
+
+func TestSimilarityScorerCachesObjects(t *testing.T) {
+	embedder := newFakeEmbedder(map[string][]float32{
+		"cat":    {1, 0},
+		"kitten": {1, 1},
+		"dog":    {0, 1},
+	})
+	scorer := NewSimilarityScorer(embedder)
+
+	if err := scorer.Prepare(context.Background(), "Cat"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		guess string
+		want  float64
+	}{
+		{"kitten", math.Sqrt2 / 2},
+		{"Dog", 0},
+		{"cat", 1},
+	}
+
+	for _, test := range tests {
+		similarity, err := scorer.Score(context.Background(), "CAT", test.guess)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if math.Abs(similarity-test.want) > 1e-6 {
+			t.Errorf("%q: got similarity %.3f, want %.3f", test.guess, similarity, test.want)
+		}
+	}
+
+	// The object was only embedded once, whatever its case, the guesses each
+	// time.
+	if embedder.calls["cat"] != 2 || embedder.calls["kitten"] != 1 || embedder.calls["dog"] != 1 {
+		t.Errorf("got calls %v, want the object once and each guess once", embedder.calls)
+	}
+}
+
+func TestSimilarityScorerErrors(t *testing.T) {
+	embedder := newFakeEmbedder(map[string][]float32{"cat": {1, 0}})
+	scorer := NewSimilarityScorer(embedder)
+
+	// A text the embedder returns no vector for.
+	if _, err := scorer.Score(context.Background(), "cat", "unknown"); err == nil {
+		t.Error("expected an error for a guess without a vector")
+	}
+	if _, err := scorer.Score(context.Background(), "unknown", "cat"); err == nil {
+		t.Error("expected an error for an object without a vector")
+	}
+
+	// Failures are not cached, so that the object is embedded again once the
+	// embedder recovers.
+	embedder.err = errors.New("unavailable")
+	if err := scorer.Prepare(context.Background(), "dog"); !errors.Is(err, embedder.err) {
+		t.Errorf("got error %v, want %v", err, embedder.err)
+	}
+	embedder.err = nil
+	embedder.vectors["dog"] = []float32{0, 1}
+	if err := scorer.Prepare(context.Background(), "dog"); err != nil {
+		t.Errorf("unexpected error after recovering: %v", err)
+	}
+}
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float32
+		want float64
+	}{
+		{"same", []float32{1, 2, 3}, []float32{2, 4, 6}, 1},
+		{"orthogonal", []float32{1, 0}, []float32{0, 1}, 0},
+		{"opposite", []float32{1, 0}, []float32{-1, 0}, -1},
+		{"empty", []float32{}, []float32{}, 0},
+		{"nil", nil, nil, 0},
+		{"zero", []float32{0, 0}, []float32{1, 0}, 0},
+		{"different lengths", []float32{1, 0}, []float32{1, 0, 0}, 0},
+	}
+
+	for _, test := range tests {
+		got := cosineSimilarity(test.a, test.b)
+		if math.IsNaN(got) || math.Abs(got-test.want) > 1e-6 {
+			t.Errorf("%s: got %.3f, want %.3f", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSimilarityConfigCredit(t *testing.T) {
+	config := &SimilarityConfig{PartialThreshold: .5, FullThreshold: .75}
+
+	tests := []struct {
+		similarity float64
+		stars      int
+		named      bool
+	}{
+		{-1, 0, false},
+		{0, 0, false},
+		{.4999, 0, false},
+		{.5, 1, false},
+		{.7499, 1, false},
+		{.75, 2, true},
+		{1, 2, true},
+	}
+
+	for _, test := range tests {
+		if stars, named := config.credit(test.similarity); stars != test.stars || named != test.named {
+			t.Errorf("similarity %.4f: got %d stars, named %t, want %d stars, named %t",
+				test.similarity, stars, named, test.stars, test.named)
+		}
+	}
+}
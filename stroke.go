@@ -0,0 +1,91 @@
+package main
+
+import (
+	"math"
+)
+
+/******************************************************************************
+ strokePoint
+******************************************************************************/
+
+// strokePoint is a position on the canvas surface, in pixels.
+type strokePoint struct {
+	x, y float64
+}
+
+func (p strokePoint) distance(other strokePoint) float64 {
+	return math.Hypot(other.x-p.x, other.y-p.y)
+}
+
+/******************************************************************************
+ Stroke Functions
+******************************************************************************/
+
+// interpolateStroke returns the positions at which to stamp the brush head
+// for the segment between the last two samples of a stroke, from, and not
+// including, the second-to-last up to the last, no more than spacing pixels
+// apart however far the segment bends.  With smoothing, the segment follows a
+// Catmull-Rom spline through the last three samples, with the tangent at the
+// last sample extrapolated so that nothing waits on the next sample;
+// otherwise it is a straight line.
+// A stroke of a single sample is stamped once.
+func interpolateStroke(samples []strokePoint, spacing float64, smoothing bool) []strokePoint {
+	switch len(samples) {
+	case 0:
+		return nil
+	case 1:
+		return []strokePoint{samples[0]}
+	}
+
+	p1, p2 := samples[len(samples)-2], samples[len(samples)-1]
+	length := p1.distance(p2)
+	if length == 0 {
+		return nil
+	}
+
+	p0 := strokePoint{2*p1.x - p2.x, 2*p1.y - p2.y}
+	if len(samples) > 2 {
+		p0 = samples[len(samples)-3]
+	}
+	p3 := strokePoint{2*p2.x - p1.x, 2*p2.y - p1.y}
+
+	if smoothing {
+		length = catmullRomSpan(p0, p1, p2, p3)
+	}
+
+	steps := int(math.Ceil(length / math.Max(spacing, 1)))
+	stamps := make([]strokePoint, steps)
+	for i := 1; i <= steps; i++ {
+		t := float64(i) / float64(steps)
+		if smoothing {
+			stamps[i-1] = catmullRom(p0, p1, p2, p3, t)
+		} else {
+			stamps[i-1] = strokePoint{p1.x + (p2.x-p1.x)*t, p1.y + (p2.y-p1.y)*t}
+		}
+	}
+	return stamps
+}
+
+// catmullRom returns the point at t, from 0 to 1, of the uniform Catmull-Rom
+// spline segment between p1 and p2.
+func catmullRom(p0, p1, p2, p3 strokePoint, t float64) strokePoint {
+	t2, t3 := t*t, t*t*t
+	interpolate := func(v0, v1, v2, v3 float64) float64 {
+		return .5 * (2*v1 + (v2-v0)*t + (2*v0-5*v1+4*v2-v3)*t2 + (3*v1-v0-3*v2+v3)*t3)
+	}
+	return strokePoint{
+		x: interpolate(p0.x, p1.x, p2.x, p3.x),
+		y: interpolate(p0.y, p1.y, p2.y, p3.y),
+	}
+}
+
+// catmullRomSpan returns a bound on the speed, in pixels per unit of t, of the
+// spline segment between p1 and p2, such that points 1/n of t apart are never
+// more than span/n pixels apart.  The segment is the Bezier curve with
+// control points p1, b1, b2 and p2, whose speed never exceeds three times the
+// longest leg of that control polygon.
+func catmullRomSpan(p0, p1, p2, p3 strokePoint) float64 {
+	b1 := strokePoint{p1.x + (p2.x-p0.x)/6, p1.y + (p2.y-p0.y)/6}
+	b2 := strokePoint{p2.x - (p3.x-p1.x)/6, p2.y - (p3.y-p1.y)/6}
+	return 3 * math.Max(p1.distance(b1), math.Max(b1.distance(b2), b2.distance(p2)))
+}
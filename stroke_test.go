@@ -0,0 +1,90 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+/******************************************************************************
+ Test Helpers
+******************************************************************************/
+
+// strokeStamps interpolates every segment of the given samples as they arrive,
+// the way a brush paints them, returning all the stamps of the stroke.
+func strokeStamps(samples []strokePoint, spacing float64, smoothing bool) (stamps []strokePoint) {
+	for i := range samples {
+		stamps = append(stamps, interpolateStroke(samples[:i+1], spacing, smoothing)...)
+	}
+	return
+}
+
+/******************************************************************************
+ Stroke Tests
+******************************************************************************/
+
+func TestInterpolateStrokeHasNoGaps(t *testing.T) {
+	samples := []strokePoint{{0, 0}, {120, 0}, {120, 90}, {10, 200}, {300, 210}, {290, 20}}
+
+	for _, smoothing := range []bool{false, true} {
+		for _, spacing := range []float64{1, 4, 25} {
+			stamps := strokeStamps(samples, spacing, smoothing)
+			for i := 1; i < len(stamps); i++ {
+				if gap := stamps[i-1].distance(stamps[i]); gap > spacing+1e-9 {
+					t.Errorf("smoothing %t, spacing %.0f: stamps %d and %d are %.2f pixels apart",
+						smoothing, spacing, i-1, i, gap)
+				}
+			}
+		}
+	}
+}
+
+func TestInterpolateStrokePassesThroughSamples(t *testing.T) {
+	samples := []strokePoint{{0, 0}, {50, 40}, {90, -30}, {160, 10}}
+
+	for _, smoothing := range []bool{false, true} {
+		for i := range samples {
+			stamps := interpolateStroke(samples[:i+1], 7, smoothing)
+			if len(stamps) == 0 {
+				t.Fatalf("smoothing %t: no stamps for sample %d", smoothing, i)
+			}
+			if last := stamps[len(stamps)-1]; last.distance(samples[i]) > 1e-9 {
+				t.Errorf("smoothing %t: segment to sample %d ends at %v, want %v", smoothing, i, last, samples[i])
+			}
+		}
+	}
+}
+
+func TestInterpolateStrokeSingleSample(t *testing.T) {
+	sample := strokePoint{12, 34}
+	for _, smoothing := range []bool{false, true} {
+		if stamps := interpolateStroke([]strokePoint{sample}, 5, smoothing); len(stamps) != 1 || stamps[0] != sample {
+			t.Errorf("smoothing %t: got stamps %v, want just %v", smoothing, stamps, sample)
+		}
+	}
+
+	if stamps := interpolateStroke([]strokePoint{sample, sample}, 5, true); len(stamps) != 0 {
+		t.Errorf("got stamps %v for a repeated sample, want none", stamps)
+	}
+	if stamps := interpolateStroke(nil, 5, true); stamps != nil {
+		t.Errorf("got stamps %v for no samples, want none", stamps)
+	}
+}
+
+func TestCatmullRom(t *testing.T) {
+	p0, p1, p2, p3 := strokePoint{-10, 5}, strokePoint{0, 0}, strokePoint{20, 10}, strokePoint{25, 40}
+
+	if got := catmullRom(p0, p1, p2, p3, 0); got.distance(p1) > 1e-9 {
+		t.Errorf("got %v at t=0, want %v", got, p1)
+	}
+	if got := catmullRom(p0, p1, p2, p3, 1); got.distance(p2) > 1e-9 {
+		t.Errorf("got %v at t=1, want %v", got, p2)
+	}
+
+	// Collinear, evenly spaced points give a straight, evenly paced segment.
+	for _, t0 := range []float64{.25, .5, .75} {
+		got := catmullRom(strokePoint{0, 0}, strokePoint{10, 10}, strokePoint{20, 20}, strokePoint{30, 30}, t0)
+		if want := (strokePoint{10 + 10*t0, 10 + 10*t0}); math.Abs(got.x-want.x) > 1e-9 || math.Abs(got.y-want.y) > 1e-9 {
+			t.Errorf("got %v at t=%.2f, want %v", got, t0, want)
+		}
+	}
+}
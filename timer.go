@@ -25,7 +25,8 @@ type Timer struct {
 	timeLeftMilli  int64
 	timeRunningOut bool
 
-	onTimerStop func()
+	onCountdownStopHandlers []func()
+	onTimerStopHandlers     []func()
 
 	stateMutex sync.Mutex
 }
@@ -79,9 +80,14 @@ func (t *Timer) tick() {
 		t.timeLastMilli = now
 		if t.countdownLeftMilli <= 1000 { // make display/human-friendly for spoken countdowns
 			t.countingDown = false
-		} else {
-			t.SetText(fmt.Sprintf("%d", int(float64(t.countdownLeftMilli)*.001)))
+			handlers := t.onCountdownStopHandlers
+			t.stateMutex.Unlock()
+			for _, handler := range handlers {
+				handler()
+			}
+			return
 		}
+		t.SetText(fmt.Sprintf("%d", int(float64(t.countdownLeftMilli)*.001)))
 		t.stateMutex.Unlock()
 		return
 	}
@@ -97,10 +103,11 @@ func (t *Timer) tick() {
 	if t.timeLeftMilli <= 0 {
 		t.timeLeftMilli = 0
 		t.SetText(fmt.Sprintf("%.3f", float32(t.timeLeftMilli)*.001))
+		handlers := t.onTimerStopHandlers
 		t.stateMutex.Unlock()
 		t.SetEnabled(false)
-		if t.onTimerStop != nil {
-			t.onTimerStop()
+		for _, handler := range handlers {
+			handler()
 		}
 		return
 	}
@@ -127,9 +134,19 @@ func (t *Timer) Reset(countdownSec, timeSec int64) {
 	t.stateMutex.Unlock()
 }
 
+// OnCountdownStop adds a handler that will be called, on the render thread,
+// when the countdown ends and the time starts running.
+func (t *Timer) OnCountdownStop(handler func()) {
+	t.stateMutex.Lock()
+	t.onCountdownStopHandlers = append(t.onCountdownStopHandlers, handler)
+	t.stateMutex.Unlock()
+}
+
+// OnTimerStop adds a handler that will be called, on the render thread, when
+// time runs out or is ended early with SetTimeRemaining(0).
 func (t *Timer) OnTimerStop(handler func()) {
 	t.stateMutex.Lock()
-	t.onTimerStop = handler
+	t.onTimerStopHandlers = append(t.onTimerStopHandlers, handler)
 	t.stateMutex.Unlock()
 }
 
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+)
+
+// historyRequest is a request to undo or redo a stroke, queued until the
+// brush's next update.
+type historyRequest int
+
+const (
+	undoRequest historyRequest = iota
+	redoRequest
+)
+
+/******************************************************************************
+ strokeRecord
+******************************************************************************/
+
+// strokeRecord is a finished stroke, as kept for undo: the region of the
+// canvas surface it changed, the pixels of that region before and after the
+// stroke and the ink it took from each tank.
+type strokeRecord struct {
+	rect   dirtyRect
+	before []uint8
+	after  []uint8
+	ink    []float64
+}
+
+// newStrokeRecord records the stroke painted into the given region of an RGBA
+// buffer of the given width, with the tiles it saved before painting over
+// them; the rest of the region it left as it was.
+func newStrokeRecord(buffer []uint8, width int, rect dirtyRect, tiles strokeTiles, ink []float64) *strokeRecord {
+	after := readRegion(buffer, width, rect)
+	before := append([]uint8(nil), after...)
+	tiles.restore(before, rect)
+
+	return &strokeRecord{
+		rect:   rect,
+		before: before,
+		after:  after,
+		ink:    append([]float64(nil), ink...),
+	}
+}
+
+/******************************************************************************
+ strokeTiles
+******************************************************************************/
+
+// strokeTileSize is the width and height, in pixels, of the tiles in which a
+// stroke saves the pixels it paints over.
+const strokeTileSize = 32
+
+// strokeTiles holds the pixels of the canvas surface as they were before the
+// stroke being painted, for each tile of the surface the stroke has reached,
+// saved as it reaches them so that the cost follows the area painted rather
+// than the size of the canvas.
+type strokeTiles map[tileKey]*savedTile
+
+// tileKey is the column and row of a tile.
+type tileKey struct {
+	x, y int
+}
+
+type savedTile struct {
+	rect   dirtyRect
+	pixels []uint8
+}
+
+// save keeps the pixels of each tile of the given region of an RGBA buffer of
+// the given size that is not already kept.  It must be called before the
+// region is painted.
+func (t strokeTiles) save(buffer []uint8, width, height int, rect dirtyRect) {
+	if rect.empty() {
+		return
+	}
+
+	for y := rect.minY / strokeTileSize; y*strokeTileSize < rect.maxY; y++ {
+		for x := rect.minX / strokeTileSize; x*strokeTileSize < rect.maxX; x++ {
+			key := tileKey{x, y}
+			if _, saved := t[key]; saved {
+				continue
+			}
+
+			tileRect := newDirtyRect(x*strokeTileSize, y*strokeTileSize, (x+1)*strokeTileSize, (y+1)*strokeTileSize).
+				clip(width, height)
+			t[key] = &savedTile{rect: tileRect, pixels: readRegion(buffer, width, tileRect)}
+		}
+	}
+}
+
+// restore copies the saved pixels within the given region into pixels, as
+// returned by readRegion for that region.
+func (t strokeTiles) restore(pixels []uint8, rect dirtyRect) {
+	for _, tile := range t {
+		overlap := tile.rect.intersect(rect)
+		if overlap.empty() {
+			continue
+		}
+
+		rowLen := overlap.width() * 4
+		for y := overlap.minY; y < overlap.maxY; y++ {
+			from := ((y-tile.rect.minY)*tile.rect.width() + overlap.minX - tile.rect.minX) * 4
+			to := ((y-rect.minY)*rect.width() + overlap.minX - rect.minX) * 4
+			copy(pixels[to:to+rowLen], tile.pixels[from:from+rowLen])
+		}
+	}
+}
+
+/******************************************************************************
+ strokeHistory
+******************************************************************************/
+
+// strokeHistory is the undo/redo stack of an InkBrush, holding up to limit
+// strokes that can be undone; any more and the oldest is forgotten.
+type strokeHistory struct {
+	undo  []*strokeRecord
+	redo  []*strokeRecord
+	limit int
+}
+
+// push records a new stroke, which cannot be redone past.
+func (h *strokeHistory) push(record *strokeRecord) {
+	h.undo = append(h.undo, record)
+	if len(h.undo) > h.limit {
+		h.undo = h.undo[len(h.undo)-h.limit:]
+	}
+	h.redo = nil
+}
+
+// lastUndo returns the stroke that would be undone next, or nil.
+func (h *strokeHistory) lastUndo() *strokeRecord {
+	if len(h.undo) == 0 {
+		return nil
+	}
+	return h.undo[len(h.undo)-1]
+}
+
+// lastRedo returns the stroke that would be redone next, or nil.
+func (h *strokeHistory) lastRedo() *strokeRecord {
+	if len(h.redo) == 0 {
+		return nil
+	}
+	return h.redo[len(h.redo)-1]
+}
+
+// undoLast moves the stroke returned by lastUndo to the redo stack.
+func (h *strokeHistory) undoLast() {
+	h.redo = append(h.redo, h.undo[len(h.undo)-1])
+	h.undo = h.undo[:len(h.undo)-1]
+}
+
+// redoLast moves the stroke returned by lastRedo back to the undo stack.
+func (h *strokeHistory) redoLast() {
+	h.undo = append(h.undo, h.redo[len(h.redo)-1])
+	h.redo = h.redo[:len(h.redo)-1]
+}
+
+func (h *strokeHistory) clear() {
+	h.undo = nil
+	h.redo = nil
+}
+
+/******************************************************************************
+ Undo Functions
+******************************************************************************/
+
+// readRegion copies the given region out of an RGBA buffer of the given
+// width, row by row.
+func readRegion(buffer []uint8, width int, rect dirtyRect) []uint8 {
+	rowLen := rect.width() * 4
+	pixels := make([]uint8, 0, rowLen*rect.height())
+	for y := rect.minY; y < rect.maxY; y++ {
+		start := (y*width + rect.minX) * 4
+		pixels = append(pixels, buffer[start:start+rowLen]...)
+	}
+	return pixels
+}
+
+// writeRegion copies pixels, as returned by readRegion, back into the given
+// region of an RGBA buffer of the given width.
+func writeRegion(buffer []uint8, width int, rect dirtyRect, pixels []uint8) {
+	rowLen := rect.width() * 4
+	for y := rect.minY; y < rect.maxY; y++ {
+		start := (y*width + rect.minX) * 4
+		copy(buffer[start:start+rowLen], pixels[(y-rect.minY)*rowLen:])
+	}
+}
+
+// regionEquals returns true if the given region of an RGBA buffer of the
+// given width holds the given pixels, as returned by readRegion.
+func regionEquals(buffer []uint8, width int, rect dirtyRect, pixels []uint8) bool {
+	rowLen := rect.width() * 4
+	for y := rect.minY; y < rect.maxY; y++ {
+		start := (y*width + rect.minX) * 4
+		offset := (y - rect.minY) * rowLen
+		if !bytes.Equal(buffer[start:start+rowLen], pixels[offset:offset+rowLen]) {
+			return false
+		}
+	}
+	return true
+}
@@ -1,69 +1,29 @@
 package main
 
 import (
-	"encoding/base64"
 	"fmt"
-	"io"
 	"os"
 	"path"
-	"path/filepath"
-	"sort"
-	"strings"
 	"time"
 )
 
+// panicOnErr is for errors that only a bug can cause; errors the player can
+// fix, such as a missing file, are reported by exitOnStartupErr instead.
 func panicOnErr(err error) {
 	if err != nil {
 		panic(err)
 	}
 }
 
-func prepareImageDirectory(tempDirectory string) string {
-	dir := path.Join(tempDirectory, fmt.Sprintf("%d", time.Now().UnixMilli()))
+// prepareImageDirectory makes a directory for this run's snapshots under the
+// temp directory, marked as the app's own for SnapshotStore to find later.
+func prepareImageDirectory(tempDirectory string) (string, error) {
+	dir := path.Join(tempDirectory, snapshotsDirectory, fmt.Sprintf("%d", time.Now().UnixMilli()))
 	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
-		panic(fmt.Errorf("error creating directory: %w", err))
+		return "", fmt.Errorf("error creating directory: %w", err)
 	}
-	return dir
-}
-
-func getLatestDrawing(dir string) string {
-	files, err := os.ReadDir(dir)
-	if err != nil {
-		panic(fmt.Errorf("error reading directory: %w", err))
-	}
-
-	var fileNames []string
-	for _, file := range files {
-		if !file.IsDir() && strings.HasSuffix(file.Name(), ".png") {
-			fileNames = append(fileNames, file.Name())
-		}
-	}
-
-	if len(fileNames) == 0 {
-		return ""
+	if err := os.WriteFile(path.Join(dir, snapshotDirectoryMarker), nil, 0660); err != nil {
+		return "", fmt.Errorf("error creating directory: %w", err)
 	}
-
-	sort.Strings(fileNames)
-	lastFileName := fileNames[len(fileNames)-1]
-	return filepath.Join(dir, lastFileName)
-}
-
-func getImageB64(imagePath string) string {
-	imageFile, err := os.Open(imagePath)
-	if err != nil {
-		panic(fmt.Errorf("error opening image: %w", err))
-	}
-	defer func(file *os.File) {
-		e := file.Close()
-		if e != nil {
-			panic(fmt.Errorf("error closing image: %w", e))
-		}
-	}(imageFile)
-
-	var imageData []byte
-	if imageData, err = io.ReadAll(imageFile); err != nil {
-		panic(fmt.Errorf("error reading file: %w", err))
-	}
-
-	return base64.StdEncoding.EncodeToString(imageData)
+	return dir, nil
 }
@@ -3,15 +3,19 @@ package main
 import (
 	"context"
 	"fmt"
+	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/go-gl/mathgl/mgl32"
 	"github.com/tonybillings/gfx"
 	"github.com/tonybillings/gfx/examples/ui/view"
 	"github.com/tonybillings/gfx/obj"
+	"github.com/tonybillings/pictionary-gpt/matcher"
 	"github.com/tonybillings/pictionary-gpt/models"
 	"github.com/tonybillings/pictionary-gpt/textures"
 	"image/color"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -20,24 +24,26 @@ var (
 	goldStarColor   = gfx.Darken(gfx.Yellow, .5)
 )
 
-func getExportFunc(gameView gfx.WindowObject, imageDirectory string) func() {
-	canvas := gameView.Child("Canvas").(*gfx.Canvas)
-	exportFunc := func() {
-		if canvas.Initialized() {
-			canvas.Export(imageDirectory)
-		}
-	}
-	return exportFunc
+func getCaptureFunc(pictView gfx.WindowObject) func(context.Context) (*Snapshot, error) {
+	capture := pictView.Child("CanvasCapture").(*CanvasCapture)
+	return capture.Capture
 }
 
-func getGuessFunc(gameView gfx.WindowObject) func(string) {
-	guess1 := gameView.Child("GuessLabel1").(*gfx.Label)
-	guess2 := gameView.Child("GuessLabel2").(*gfx.Label)
-	starContainer := gameView.Child("StarContainer").(*StarContainer)
-	challengeLabel := gameView.Child("ChallengeLabel").(*gfx.Label)
-	timer := gameView.Child("Timer").(*Timer)
-
-	guessFunc := func(gptGuess string) {
+// getGuessFunc returns a function that displays the guess and its alternates
+// and, in game mode (when given a session), awards stars for it while the
+// round accepts guesses.  A star is awarded for the color, whether named in
+// the guess or detected by the guesser, and two for the object.  Failing
+// that, one star is awarded for an object the scorer (if given) finds similar
+// or named by one of the alternates.  Only the guess itself can win a round.
+func getGuessFunc(pictView gfx.WindowObject, session *GameSession, scorer *SimilarityScorer) func(*Guess) {
+	guess1 := pictView.Child("GuessLabel1").(*gfx.Label)
+	guess2 := pictView.Child("GuessLabel2").(*gfx.Label)
+	alternatesLabel := pictView.Child("AlternatesLabel").(*gfx.Label)
+	starContainer, _ := pictView.Child("StarContainer").(*StarContainer)
+	answerMatcher := matcher.New().SetMaxDistance(cfg.Guesser.MatchDistance)
+
+	guessFunc := func(guess *Guess) {
+		gptGuess := guess.Text
 		words := strings.Split(gptGuess, " ")
 		if len(words) > 2 { // crude text wrapping
 			guess1.SetText(fmt.Sprintf("%s %s", words[0], words[1]))
@@ -47,46 +53,91 @@ func getGuessFunc(gameView gfx.WindowObject) func(string) {
 			guess2.SetText("")
 		}
 
-		gptGuess = strings.ToLower(gptGuess)
-		gptGuess = strings.ReplaceAll(gptGuess, "?", "")
-		gptGuess = strings.ReplaceAll(gptGuess, ",", "")
-		words = strings.Split(gptGuess, " ")
+		alternates := ""
+		if len(guess.Alternates) > 0 {
+			alternates = "or " + strings.Join(guess.Alternates, ", or ")
+		}
+		if guess.Confidence > 0 {
+			alternates = strings.TrimSpace(fmt.Sprintf("(%.0f%% sure) %s", guess.Confidence*100, alternates))
+		}
+		alternatesLabel.SetText(alternates)
 
-		if guess1.Text() == "?" {
-			starContainer.SetStarVisibility(1, false)
-			starContainer.SetStarVisibility(2, false)
-			starContainer.SetStarVisibility(3, false)
-		} else {
-			answer := strings.ToLower(challengeLabel.Text())
-			if strings.Contains(gptGuess, answer) {
-				starContainer.SetStarVisibility(1, true)
-				starContainer.SetStarVisibility(2, true)
-				starContainer.SetStarVisibility(3, true)
-				timer.SetTimeRemaining(0)
-			} else if strings.Contains(answer, words[0]) {
-				starContainer.SetStarVisibility(1, true)
-				starContainer.SetStarVisibility(2, false)
-				starContainer.SetStarVisibility(3, false)
-			} else {
-				starContainer.SetStarVisibility(1, false)
-				starContainer.SetStarVisibility(2, false)
-				starContainer.SetStarVisibility(3, false)
-
-				for _, word := range words[1:] {
-					if strings.Contains(answer, word) {
-						starContainer.SetStarVisibility(1, true)
-						starContainer.SetStarVisibility(2, true)
-						starContainer.SetStarVisibility(3, false)
-						break
-					}
-				}
+		if session == nil || !session.Guess(gptGuess) {
+			return
+		}
+
+		challenge := session.Round().Challenge
+		match := answerMatcher.Match(challenge.Color, challenge.Object, gptGuess)
+
+		if !match.ColorMatched && guess.Color != "" {
+			match.ColorMatched = answerMatcher.Match(challenge.Color, "", guess.Color).ColorMatched
+		}
+
+		stars := 0
+		if match.ColorMatched {
+			stars++
+		}
+
+		partialCredit := false
+		for _, alternate := range guess.Alternates {
+			if answerMatcher.Match(challenge.Color, challenge.Object, alternate).ObjectMatched {
+				partialCredit = true
+				break
 			}
 		}
+
+		if match.ObjectMatched {
+			stars += 2
+		} else if partialCredit {
+			stars++
+		} else if scorer != nil && match.ObjectGuess != "" {
+			similarity, err := scorer.Score(context.Background(), challenge.Object, match.ObjectGuess)
+			if err == nil { // otherwise no partial credit, then
+				credit, named := cfg.Similarity.credit(similarity)
+				match.ObjectMatched = named
+				stars += credit
+			}
+		}
+
+		session.Award(stars)
+		starContainer.SetStarVisibility(1, stars >= 1)
+		starContainer.SetStarVisibility(2, stars >= 2)
+		starContainer.SetStarVisibility(3, stars >= 3)
+
+		if match.Matched() {
+			_ = session.Win()
+		}
 	}
 
 	return guessFunc
 }
 
+// hotSeatPrompt returns the text prompting the next player to choose a
+// difficulty or, once the match is over, declaring the winner.
+func hotSeatPrompt(match *HotSeatMatch) string {
+	winners := match.Winners()
+
+	switch len(winners) {
+	case 0:
+		return fmt.Sprintf("%s, choose a difficulty!", match.CurrentPlayer())
+	case 1:
+		return fmt.Sprintf("%s wins with %d points!", winners[0].Player, winners[0].Points)
+	default:
+		names := make([]string, len(winners))
+		for i, winner := range winners {
+			names[i] = winner.Player
+		}
+		return fmt.Sprintf("%s tie with %d points!", strings.Join(names, " and "), winners[0].Points)
+	}
+}
+
+func getStatusFunc(pictView gfx.WindowObject) func(string) {
+	statusLabel := pictView.Child("StatusLabel").(*gfx.Label)
+	return func(status string) {
+		statusLabel.SetText(status)
+	}
+}
+
 func newInkMeter(rgba color.RGBA) (outer, inner gfx.WindowObject) {
 	inkMeter := gfx.NewWindowObject()
 	inkMeter.
@@ -106,26 +157,58 @@ func newInkMeter(rgba color.RGBA) (outer, inner gfx.WindowObject) {
 }
 
 func newBrushControls(brush *InkBrush) gfx.WindowObject {
-	redInkMeter, redInkMeterInner := newInkMeter(gfx.Red)
-	brush.OnRedInkChanged(func(newInkLevel float64) {
-		redInkMeterInner.SetScaleY(float32(newInkLevel))
-	})
+	brushControls := view.NewBrushControls(&brush.BasicBrush)
+	brushControls.SetPositionY(.2)
 
-	greenInkMeter, greenInkMeterInner := newInkMeter(gfx.Green)
-	brush.OnGreenInkChanged(func(newInkLevel float64) {
-		greenInkMeterInner.SetScaleY(float32(newInkLevel))
-	})
+	// The first three tanks of every model get a meter on the red, green and
+	// blue sliders, which the cyan, magenta and yellow tanks pair with
+	// naturally, while a fourth (key) tank gets one beside the color preview.
+	meterParents := []string{"RedSlider", "GreenSlider", "BlueSlider", "ColorPreview"}
+
+	meters := make(map[string][]gfx.WindowObject)
+	meterLevels := make(map[string][]gfx.WindowObject)
+	for _, model := range inkModels {
+		for i, tank := range model.Tanks() {
+			meter, meterLevel := newInkMeter(tank.Color)
+			meter.SetVisibility(false)
+			if meterParents[i] == "ColorPreview" {
+				meter.
+					SetAnchor(gfx.MiddleLeft).
+					SetScale(mgl32.Vec3{.1, .8})
+			}
+			brushControls.Child(meterParents[i]).AddChild(meter)
 
-	blueInkMeter, blueInkMeterInner := newInkMeter(gfx.Blue)
-	brush.OnBlueInkChanged(func(newInkLevel float64) {
-		blueInkMeterInner.SetScaleY(float32(newInkLevel))
-	})
+			meters[model.Name()] = append(meters[model.Name()], meter)
+			meterLevels[model.Name()] = append(meterLevels[model.Name()], meterLevel)
+		}
+	}
 
-	brushControls := view.NewBrushControls(&brush.BasicBrush)
-	brushControls.SetPositionY(.2)
-	brushControls.Child("RedSlider").AddChild(redInkMeter)
-	brushControls.Child("GreenSlider").AddChild(greenInkMeter)
-	brushControls.Child("BlueSlider").AddChild(blueInkMeter)
+	var activeMeterLevels []gfx.WindowObject
+	var activeMeterMutex sync.Mutex
+	showMeters := func(model InkModel) {
+		activeMeterMutex.Lock()
+		defer activeMeterMutex.Unlock()
+
+		for name, modelMeters := range meters {
+			for _, meter := range modelMeters {
+				meter.SetVisibility(name == model.Name())
+			}
+		}
+		activeMeterLevels = meterLevels[model.Name()]
+	}
+
+	brush.OnInkModelChanged(showMeters)
+	brush.OnInkChanged(func(newInkLevels []float64) {
+		activeMeterMutex.Lock()
+		defer activeMeterMutex.Unlock()
+
+		for i, level := range newInkLevels {
+			if i < len(activeMeterLevels) {
+				activeMeterLevels[i].SetScaleY(float32(level))
+			}
+		}
+	})
+	showMeters(brush.InkModel())
 
 	refillButton := NewRainbowButton()
 	refillButton.
@@ -145,7 +228,8 @@ func newBrushControls(brush *InkBrush) gfx.WindowObject {
 	return brushControls
 }
 
-func newGameControls(challengeLabel *gfx.Label, brush *InkBrush, starContainer *StarContainer) gfx.WindowObject {
+func newGameControls(challengeLabel, scoreLabel, statusLabel *gfx.Label, brush *InkBrush, starContainer *StarContainer,
+	session *GameSession) gfx.WindowObject {
 	gameControls := gfx.NewView()
 	gameControls.
 		SetBorderColor(gfx.Purple).
@@ -193,81 +277,105 @@ func newGameControls(challengeLabel *gfx.Label, brush *InkBrush, starContainer *
 		SetMarginLeft(1.).
 		SetScale(mgl32.Vec3{.15, .4})
 
-	timer := NewTimer(timerCountdownSec, timerNormalSec)
+	timer := NewTimer(cfg.Timer.CountdownSec, cfg.Timer.NormalSec)
 	timer.SetFontSize(.5)
 	timer.SetVisibility(false).SetEnabled(false)
-	timer.OnTimerStop(func() {
-		timer.SetVisibility(false).SetEnabled(false)
-		newGameLabel.SetVisibility(true).SetEnabled(true)
-		easyButton.SetVisibility(true).SetEnabled(true)
-		normalButton.SetVisibility(true).SetEnabled(true)
-		hardButton.SetVisibility(true).SetEnabled(true)
-	})
-
-	client := newGptClient()
-	objectHistory := make([]string, 0)
-	var gameMutex sync.Mutex
 
-	startGame := func(difficulty int) {
-		gameMutex.Lock()
-		challengeLabel.SetText("")
-		brush.RefillInkInstantly()
+	setNewGameButtonsVisible := func(visible bool) {
+		newGameLabel.SetVisibility(visible).SetEnabled(visible)
+		easyButton.SetVisibility(visible).SetEnabled(visible)
+		normalButton.SetVisibility(visible).SetEnabled(visible)
+		hardButton.SetVisibility(visible).SetEnabled(visible)
+	}
 
-		newGameLabel.SetVisibility(false).SetEnabled(false)
-		easyButton.SetVisibility(false).SetEnabled(false)
-		normalButton.SetVisibility(false).SetEnabled(false)
-		hardButton.SetVisibility(false).SetEnabled(false)
+	timer.OnCountdownStop(func() {
+		_ = session.CountdownFinished()
+	})
+	timer.OnTimerStop(func() {
+		_ = session.TimeUp()
+	})
 
-		go func() {
-			defer gameMutex.Unlock()
+	match := session.HotSeatMatch()
+	if match != nil {
+		scoreLabel.SetText(hotSeatPrompt(match))
+	}
 
-			prompt := gptStartGamePrompt
-			timerSec := int64(0)
+	session.OnTransition(func(event RoundEvent) {
+		switch event.To {
+		case FetchingChallenge:
+			challengeLabel.SetText("")
+			scoreLabel.SetText("")
+			if match != nil {
+				turn, turns := match.Turn()
+				scoreLabel.SetText(fmt.Sprintf("%s's turn (%d of %d), get ready!", event.Round.Player, turn, turns))
+			}
+			brush.SetInkModel(inkModelFor(event.Round.Difficulty))
+			brush.ResetInkUsed()
+			setNewGameButtonsVisible(false)
 
-			switch difficulty {
-			case 1:
-				timerSec = timerEasySec
-				prompt += "Easy"
+			switch event.Round.Difficulty {
+			case Easy:
 				starContainer.SetColor(bronzeStarColor)
-			case 2:
-				timerSec = timerNormalSec
-				prompt += "Normal"
+			case Normal:
 				starContainer.SetColor(silverStarColor)
-			case 3:
-				timerSec = timerHardSec
-				prompt += "Hard"
+			case Hard:
 				starContainer.SetColor(goldStarColor)
 			}
 
 			starContainer.Reset()
+		case Countdown:
+			statusLabel.SetText("")
+			challengeLabel.SetText(event.Round.Challenge.String())
 
-			resp, err := client.CreateChatCompletion(
-				context.Background(),
-				*newTextCompletionRequest(prompt, fmt.Sprintf("[%s]", strings.Join(objectHistory, "|"))),
-			)
-
-			if err == nil {
-				challenge := formatChallenge(resp.Choices[0].Message.Content)
-				challengeLabel.SetText(challenge)
-				challengeWords := strings.Split(challenge, " ")
-				objectHistory = append(objectHistory, challengeWords[1])
+			timer.Reset(cfg.Timer.CountdownSec, int64(event.Round.TimeAllowed/time.Second))
+			timer.SetVisibility(true).SetEnabled(true)
+		case Won, TimedOut:
+			if match != nil {
+				scoreLabel.SetText(fmt.Sprintf("%s scored %d points. %s",
+					event.Round.Player, event.Round.Score.Points, hotSeatPrompt(match)))
 			} else {
-				panic(fmt.Errorf("API error: %w\n", err))
+				scoreLabel.SetText(fmt.Sprintf("%d points this round, %d in total",
+					event.Round.Score.Points, session.Total()))
 			}
+			timer.SetVisibility(false).SetEnabled(false)
+			setNewGameButtonsVisible(true)
+		case Aborted:
+			if isConfigurationError(event.Round.Err) {
+				reportFatalError(fmt.Errorf("API error: %w", event.Round.Err))
+			}
+			statusLabel.SetText(fmt.Sprintf("Could not start game (%s)", describeError(event.Round.Err)))
+			if match != nil {
+				scoreLabel.SetText(hotSeatPrompt(match))
+			}
+			timer.SetVisibility(false).SetEnabled(false)
+			setNewGameButtonsVisible(true)
+		}
+	})
 
-			timer.Reset(timerCountdownSec, timerSec)
-			timer.SetVisibility(true).SetEnabled(true)
-		}()
+	startGame := func(difficulty Difficulty) {
+		timerSec := int64(0)
+
+		switch difficulty {
+		case Easy:
+			timerSec = cfg.Timer.EasySec
+		case Normal:
+			timerSec = cfg.Timer.NormalSec
+		case Hard:
+			timerSec = cfg.Timer.HardSec
+		}
+
+		// refused while a round is in progress
+		_ = session.StartRound(context.Background(), difficulty, time.Duration(timerSec)*time.Second)
 	}
 
 	easyButton.OnClick(func(_ gfx.WindowObject, _ *gfx.MouseState) {
-		startGame(1)
+		startGame(Easy)
 	})
 	normalButton.OnClick(func(_ gfx.WindowObject, _ *gfx.MouseState) {
-		startGame(2)
+		startGame(Normal)
 	})
 	hardButton.OnClick(func(_ gfx.WindowObject, _ *gfx.MouseState) {
-		startGame(3)
+		startGame(Hard)
 	})
 
 	gameControls.AddChildren(newGameLabel, easyButton, normalButton, hardButton, timer)
@@ -336,7 +444,43 @@ func newStarContainer(win *gfx.Window) *StarContainer {
 	return container
 }
 
-func newGameView(win *gfx.Window, exportDirectory ...string) gfx.WindowObject {
+// addUndoKeys binds Ctrl+Z to undo the brush's last stroke and Ctrl+Y (or
+// Ctrl+Shift+Z) to redo it, while active returns true.  Key events carry no
+// modifiers, so the Ctrl and Shift keys are tracked as they are pressed and
+// released.
+func addUndoKeys(win *gfx.Window, receiver any, brush *InkBrush, active func() bool) {
+	var ctrlDown, shiftDown atomic.Bool
+	trackModifier := func(down *atomic.Bool, keys ...glfw.Key) {
+		for _, key := range keys {
+			win.AddKeyEventHandler(receiver, key, glfw.Press, func(_ *gfx.Window, _ glfw.Key, _ glfw.Action) {
+				down.Store(true)
+			})
+			win.AddKeyEventHandler(receiver, key, glfw.Release, func(_ *gfx.Window, _ glfw.Key, _ glfw.Action) {
+				down.Store(false)
+			})
+		}
+	}
+	trackModifier(&ctrlDown, glfw.KeyLeftControl, glfw.KeyRightControl)
+	trackModifier(&shiftDown, glfw.KeyLeftShift, glfw.KeyRightShift)
+
+	win.AddKeyEventHandler(receiver, glfw.KeyZ, glfw.Press, func(_ *gfx.Window, _ glfw.Key, _ glfw.Action) {
+		switch {
+		case !ctrlDown.Load() || !active():
+		case shiftDown.Load():
+			brush.Redo()
+		default:
+			brush.Undo()
+		}
+	})
+	win.AddKeyEventHandler(receiver, glfw.KeyY, glfw.Press, func(_ *gfx.Window, _ glfw.Key, _ glfw.Action) {
+		if ctrlDown.Load() && active() {
+			brush.Redo()
+		}
+	})
+}
+
+func newGameView(win *gfx.Window, statusLabel *gfx.Label, session *GameSession,
+	exportDirectory ...string) gfx.WindowObject {
 	exportDir := ""
 	if len(exportDirectory) > 0 {
 		exportDir = exportDirectory[0]
@@ -350,18 +494,63 @@ func newGameView(win *gfx.Window, exportDirectory ...string) gfx.WindowObject {
 		SetScale(mgl32.Vec3{.75, .75}).
 		SetPositionX(.2)
 
-	brush := NewInkBrush()
+	brush := NewInkBrush().
+		SetSmoothing(cfg.Brush.Smoothing).
+		SetRefundInkOnUndo(cfg.Brush.UndoInk == "refund")
 	brush.
 		SetBrushHead(gfx.RoundBrushHead).
 		SetSize(0.005).
 		SetColor(gfx.Black)
 	brush.SetCanvas(canvas)
-	canvas.AddChild(brush)
+	canvas.AddChildren(brush, NewCanvasCapture(canvas))
 
 	brushControls := newBrushControls(brush)
 
 	canvasControls := view.NewCanvasControls(canvas, brush, exportDir)
 
+	// The reset button clears the canvas by itself, so the brush has to be
+	// cleared along with it.
+	for _, child := range canvasControls.Children() {
+		if button, ok := child.(*gfx.Button); ok && button.Text() == "Reset" {
+			button.OnClick(func(_ gfx.WindowObject, _ *gfx.MouseState) {
+				brush.ClearCanvas()
+			})
+		}
+	}
+
+	redoButton := gfx.NewButton()
+	redoButton.
+		SetText("Redo").
+		SetFontSize(.5).
+		SetMouseDownFillColor(gfx.Darken(gfx.White, .5)).
+		SetMouseDownBorderColor(gfx.White).
+		SetMouseEnterBorderColor(gfx.White).
+		SetBorderThickness(.2).
+		SetBorderColor(gfx.Purple).
+		SetFillColor(gfx.Transparent).
+		SetAnchor(gfx.MiddleRight).
+		SetMargin(gfx.Margin{Bottom: .1, Right: .05}).
+		SetScale(mgl32.Vec3{.3, .15})
+	redoButton.OnClick(func(_ gfx.WindowObject, _ *gfx.MouseState) {
+		brush.Redo()
+	})
+	canvasControls.AddChild(redoButton)
+
+	session.SetInkGauge(func() float64 {
+		return brush.InkUsed() / brush.InkCapacity()
+	})
+
+	scoreLabel := gfx.NewLabel()
+	scoreLabel.SetName("ScoreLabel")
+	scoreLabel.
+		SetText("").
+		SetFontSize(.06).
+		SetAlignment(gfx.Centered).
+		SetColor(gfx.Purple).
+		SetMaintainAspectRatio(false).
+		SetPositionY(.7)
+	canvas.AddChild(scoreLabel)
+
 	challengeLabel := gfx.NewLabel()
 	challengeLabel.SetName("ChallengeLabel")
 	challengeLabel.
@@ -373,7 +562,7 @@ func newGameView(win *gfx.Window, exportDirectory ...string) gfx.WindowObject {
 
 	starContainer := newStarContainer(win)
 
-	gameControls := newGameControls(challengeLabel, brush, starContainer)
+	gameControls := newGameControls(challengeLabel, scoreLabel, statusLabel, brush, starContainer, session)
 	canvas.AddChild(gameControls)
 
 	container := gfx.NewWindowObject()
@@ -401,7 +590,7 @@ func newHomeView() gfx.WindowObject {
 
 	help2 := gfx.NewLabel()
 	help2.
-		SetText("between Practice/Game modes").
+		SetText("between Practice/Game/History").
 		SetFontSize(.15).
 		SetMaintainAspectRatio(false).
 		SetPositionY(-.1)
@@ -410,46 +599,3 @@ func newHomeView() gfx.WindowObject {
 
 	return container
 }
-
-func NewPictionaryView(win *gfx.Window, practiceMode bool) gfx.WindowObject {
-	pictView := gfx.NewWindowObject()
-	pictView.SetMaintainAspectRatio(false)
-
-	var canvasView gfx.WindowObject
-	if practiceMode {
-		canvasView = view.NewCanvasView()
-	} else {
-		canvasView = newGameView(win)
-	}
-
-	canvasView.SetPositionX(-.2)
-
-	guess1 := gfx.NewLabel()
-	guess1.SetName("GuessLabel1")
-	guess1.SetMaintainAspectRatio(false)
-	guess1.
-		SetText("").
-		SetFontSize(.045).
-		SetAlignment(gfx.Centered).
-		SetColor(gfx.White).
-		SetAnchor(gfx.MiddleRight).
-		SetMarginRight(.01).
-		SetScaleX(.3)
-
-	guess2 := gfx.NewLabel()
-	guess2.SetName("GuessLabel2")
-	guess2.
-		SetText("").
-		SetFontSize(.045).
-		SetAlignment(gfx.Centered).
-		SetColor(gfx.White).
-		SetMaintainAspectRatio(false).
-		SetAnchor(gfx.MiddleRight).
-		SetMarginRight(.01).
-		SetMarginTop(.15).
-		SetScaleX(.3)
-
-	pictView.AddChildren(canvasView, guess1, guess2)
-
-	return pictView
-}
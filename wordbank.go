@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWordBankFile = "wordbank.json"
+)
+
+/******************************************************************************
+ WordBank
+******************************************************************************/
+
+// WordBank holds the rules of the game as data: which colors may be used at
+// each difficulty, how complex (in brush strokes) objects should be and the
+// objects themselves, each tagged with a difficulty, category and estimated
+// number of strokes needed to draw it.
+type WordBank struct {
+	Tiers []*WordBankTier  `json:"tiers"`
+	Words []*WordBankEntry `json:"words"`
+}
+
+type WordBankTier struct {
+	Difficulty Difficulty `json:"difficulty"`
+	Colors     []string   `json:"colors"`
+	MinStrokes int        `json:"minStrokes"`
+	MaxStrokes int        `json:"maxStrokes"`
+}
+
+type WordBankEntry struct {
+	Object     string     `json:"object"`
+	Difficulty Difficulty `json:"difficulty"`
+	Category   string     `json:"category"`
+	Strokes    int        `json:"strokes"`
+}
+
+/******************************************************************************
+ WordBank Functions
+******************************************************************************/
+
+func (b *WordBank) validate() error {
+	tiers := make(map[Difficulty]*WordBankTier)
+	for _, tier := range b.Tiers {
+		if _, ok := tiers[tier.Difficulty]; ok {
+			return fmt.Errorf("duplicate tier for difficulty %s", tier.Difficulty)
+		}
+		if len(tier.Colors) == 0 {
+			return fmt.Errorf("tier %s has no colors", tier.Difficulty)
+		}
+		if tier.MinStrokes < 1 || tier.MaxStrokes < tier.MinStrokes {
+			return fmt.Errorf("tier %s has an invalid stroke range", tier.Difficulty)
+		}
+		tiers[tier.Difficulty] = tier
+	}
+
+	for _, difficulty := range []Difficulty{Easy, Normal, Hard} {
+		if tiers[difficulty] == nil {
+			return fmt.Errorf("missing tier for difficulty %s", difficulty)
+		}
+	}
+
+	objects := make(map[string]bool)
+	for _, word := range b.Words {
+		if word.Object == "" {
+			return fmt.Errorf("word with empty object in tier %s", word.Difficulty)
+		}
+		if len(strings.Fields(word.Object)) > 2 {
+			return fmt.Errorf("object %s must be 1 or 2 words", word.Object)
+		}
+
+		key := strings.ToLower(word.Object)
+		if objects[key] {
+			return fmt.Errorf("duplicate object: %s", word.Object)
+		}
+		objects[key] = true
+
+		tier := tiers[word.Difficulty]
+		if tier == nil {
+			return fmt.Errorf("object %s has an unknown difficulty", word.Object)
+		}
+		if word.Strokes < tier.MinStrokes || word.Strokes > tier.MaxStrokes {
+			return fmt.Errorf("object %s needs %d strokes, outside the %d-%d range for %s",
+				word.Object, word.Strokes, tier.MinStrokes, tier.MaxStrokes, word.Difficulty)
+		}
+	}
+
+	return nil
+}
+
+func (b *WordBank) tier(difficulty Difficulty) *WordBankTier {
+	for _, tier := range b.Tiers {
+		if tier.Difficulty == difficulty {
+			return tier
+		}
+	}
+	return nil
+}
+
+// Colors returns the colors allowed for the given difficulty.
+func (b *WordBank) Colors(difficulty Difficulty) []string {
+	if tier := b.tier(difficulty); tier != nil {
+		return tier.Colors
+	}
+	return nil
+}
+
+// AllColors returns every color allowed at any difficulty, without repeats.
+func (b *WordBank) AllColors() (colors []string) {
+	seen := make(map[string]bool)
+	for _, tier := range b.Tiers {
+		for _, color := range tier.Colors {
+			if !seen[color] {
+				seen[color] = true
+				colors = append(colors, color)
+			}
+		}
+	}
+	return
+}
+
+// AllowedColor returns the canonical spelling of the given color if it may
+// be used at the given difficulty.
+func (b *WordBank) AllowedColor(difficulty Difficulty, color string) (canonical string, ok bool) {
+	for _, c := range b.Colors(difficulty) {
+		if strings.EqualFold(c, color) {
+			return c, true
+		}
+	}
+	return "", false
+}
+
+// Entries returns the objects tagged with the given difficulty.
+func (b *WordBank) Entries(difficulty Difficulty) (entries []*WordBankEntry) {
+	for _, word := range b.Words {
+		if word.Difficulty == difficulty {
+			entries = append(entries, word)
+		}
+	}
+	return
+}
+
+// colorRules describes the color palettes in the form used by the prompts.
+func (b *WordBank) colorRules() string {
+	rules := make([]string, 0, len(b.Tiers))
+	for _, tier := range b.Tiers {
+		rules = append(rules, fmt.Sprintf("if the Difficulty is set to %s, you shall choose a Color that is one of [%s]",
+			tier.Difficulty, strings.Join(tier.Colors, "|")))
+	}
+	rule := strings.Join(rules, "; ")
+	return strings.ToUpper(rule[:1]) + rule[1:] + "."
+}
+
+// LoadWordBank reads and validates the word bank found at the given path
+// within fsys, which will typically be words.Assets.
+func LoadWordBank(fsys fs.FS, path string) (*WordBank, error) {
+	data, err := fs.ReadFile(fsys, path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading word bank: %w", err)
+	}
+
+	bank := &WordBank{}
+	if err = json.Unmarshal(data, bank); err != nil {
+		return nil, fmt.Errorf("error parsing word bank: %w", err)
+	}
+
+	if err = bank.validate(); err != nil {
+		return nil, fmt.Errorf("invalid word bank: %w", err)
+	}
+
+	return bank, nil
+}
+
+/******************************************************************************
+ WordBankChallengeGenerator
+******************************************************************************/
+
+// WordBankChallengeGenerator combines a color allowed for the difficulty with
+// one of the word bank's objects for that difficulty, without requiring any
+// network access.  Given the same seed and the same sequence of calls, it will
+// always produce the same challenges.
+type WordBankChallengeGenerator struct {
+	bank *WordBank
+	rng  *rand.Rand
+
+	stateMutex sync.Mutex
+}
+
+func (g *WordBankChallengeGenerator) NextChallenge(_ context.Context, difficulty Difficulty, history []string) (*Challenge, error) {
+	colors := g.bank.Colors(difficulty)
+	entries := g.bank.Entries(difficulty)
+	if len(colors) == 0 || len(entries) == 0 {
+		return nil, fmt.Errorf("no challenges available for difficulty %s", difficulty)
+	}
+
+	used := make(map[string]bool, len(history))
+	for _, object := range history {
+		used[strings.ToLower(object)] = true
+	}
+
+	unused := make([]*WordBankEntry, 0, len(entries))
+	for _, entry := range entries {
+		if !used[strings.ToLower(entry.Object)] {
+			unused = append(unused, entry)
+		}
+	}
+
+	if len(unused) > 0 {
+		entries = unused
+	}
+
+	g.stateMutex.Lock()
+	color := colors[g.rng.Intn(len(colors))]
+	entry := entries[g.rng.Intn(len(entries))]
+	g.stateMutex.Unlock()
+
+	return &Challenge{
+		Color:      color,
+		Object:     entry.Object,
+		Difficulty: difficulty,
+	}, nil
+}
+
+// NewWordBankChallengeGenerator returns a generator that draws challenges
+// from the given word bank, using the given seed.  A seed of 0 means the
+// challenges should not be reproducible.
+func NewWordBankChallengeGenerator(bank *WordBank, seed int64) *WordBankChallengeGenerator {
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	return &WordBankChallengeGenerator{
+		bank: bank,
+		rng:  rand.New(rand.NewSource(seed)),
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+/******************************************************************************
+ WordBank Tests
+******************************************************************************/
+
+func TestLoadWordBankValidatesObjects(t *testing.T) {
+	const tiers = `"tiers": [
+		{"difficulty": "Easy", "colors": ["Red"], "minStrokes": 1, "maxStrokes": 4},
+		{"difficulty": "Normal", "colors": ["Red"], "minStrokes": 4, "maxStrokes": 8},
+		{"difficulty": "Hard", "colors": ["Red"], "minStrokes": 8, "maxStrokes": 15}]`
+
+	tests := []struct {
+		words string
+		valid bool
+	}{
+		{`{"object": "football field", "difficulty": "Hard", "strokes": 12}`, true},
+		{`{"object": "hot air balloon", "difficulty": "Hard", "strokes": 9}`, false},
+		{`{"object": "", "difficulty": "Easy", "strokes": 1}`, false},
+		{`{"object": "ball", "difficulty": "Easy", "strokes": 1}, {"object": "Ball", "difficulty": "Easy", "strokes": 1}`, false},
+		{`{"object": "ball", "difficulty": "Easy", "strokes": 5}`, false},
+	}
+
+	for _, test := range tests {
+		fsys := fstest.MapFS{"bank.json": {Data: []byte(`{` + tiers + `, "words": [` + test.words + `]}`)}}
+		if _, err := LoadWordBank(fsys, "bank.json"); (err == nil) != test.valid {
+			t.Errorf("words %s: got error %v, want valid %t", test.words, err, test.valid)
+		}
+	}
+
+	// The bank shipped with the game follows its own rules.
+	loadTestWordBank(t)
+}
+
+/******************************************************************************
+ WordBankChallengeGenerator Tests
+******************************************************************************/
+
+func TestWordBankChallengeGeneratorIsDeterministic(t *testing.T) {
+	bank := loadTestWordBank(t)
+
+	for _, difficulty := range []Difficulty{Easy, Normal, Hard} {
+		first := nextChallenges(t, NewWordBankChallengeGenerator(bank, 42), difficulty, 20, nil)
+		second := nextChallenges(t, NewWordBankChallengeGenerator(bank, 42), difficulty, 20, nil)
+		if strings.Join(first, ",") != strings.Join(second, ",") {
+			t.Errorf("%s: same seed gave different challenges:\n%v\n%v", difficulty, first, second)
+		}
+
+		other := nextChallenges(t, NewWordBankChallengeGenerator(bank, 43), difficulty, 20, nil)
+		if strings.Join(first, ",") == strings.Join(other, ",") {
+			t.Errorf("%s: different seeds gave the same challenges: %v", difficulty, first)
+		}
+	}
+}
+
+func TestWordBankChallengeGeneratorFollowsTiers(t *testing.T) {
+	bank := loadTestWordBank(t)
+	generator := NewWordBankChallengeGenerator(bank, 1)
+
+	for _, difficulty := range []Difficulty{Easy, Normal, Hard} {
+		objects := make(map[string]bool)
+		for _, entry := range bank.Entries(difficulty) {
+			objects[entry.Object] = true
+		}
+
+		for i := 0; i < 50; i++ {
+			challenge, err := generator.NextChallenge(context.Background(), difficulty, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if challenge.Difficulty != difficulty {
+				t.Errorf("got difficulty %s, want %s", challenge.Difficulty, difficulty)
+			}
+			if _, ok := bank.AllowedColor(difficulty, challenge.Color); !ok {
+				t.Errorf("%s: color %s is not allowed", difficulty, challenge.Color)
+			}
+			if !objects[challenge.Object] {
+				t.Errorf("%s: object %s is not from the tier", difficulty, challenge.Object)
+			}
+		}
+	}
+}
+
+func TestWordBankChallengeGeneratorAvoidsHistory(t *testing.T) {
+	bank := loadTestWordBank(t)
+	entries := bank.Entries(Easy)
+
+	// Leave a single object unused, in a different case than in the bank.
+	var history []string
+	for _, entry := range entries[1:] {
+		history = append(history, strings.ToUpper(entry.Object))
+	}
+
+	generator := NewWordBankChallengeGenerator(bank, 7)
+	for _, challenge := range nextChallenges(t, generator, Easy, 10, history) {
+		if !strings.HasSuffix(challenge, " "+entries[0].Object) {
+			t.Errorf("got %q, want the only unused object %q", challenge, entries[0].Object)
+		}
+	}
+
+	// Once every object is used, objects repeat rather than running out.
+	history = append(history, entries[0].Object)
+	nextChallenges(t, generator, Easy, 10, history)
+}
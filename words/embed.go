@@ -0,0 +1,6 @@
+package words
+
+import "embed"
+
+//go:embed *
+var Assets embed.FS